@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/stackmachine/pb/diff"
+)
+
+// allowEntry accepts a known breaking change, e.g.
+//
+//	{
+//	  "rule": "METHOD_REMOVED",
+//	  "path": ".helloworld.Greeter.SayGoodbye",
+//	  "expires": "2018-06-30",
+//	  "justification": "No clients call SayGoodbye"
+//	}
+//
+// Rule is the kind of the change and Path the changed element, as printed by
+// -format json. Entries stop applying after the day they expire.
+type allowEntry struct {
+	Rule          diff.Kind `json:"rule"`
+	Path          string    `json:"path"`
+	Expires       string    `json:"expires,omitempty"`
+	Justification string    `json:"justification,omitempty"`
+
+	expires time.Time
+	matched bool
+}
+
+// allowlist is read from the file passed to -allow.
+type allowlist struct {
+	filename string
+	Allow    []*allowEntry `json:"allow"`
+}
+
+func loadAllowlist(filename string) (*allowlist, error) {
+	blob, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", filename, err)
+	}
+	a := &allowlist{filename: filename}
+	if err := json.Unmarshal(blob, a); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", filename, err)
+	}
+	for _, entry := range a.Allow {
+		if entry.Rule == "" || entry.Path == "" {
+			return nil, fmt.Errorf("error parsing %s: entries need a rule and a path", filename)
+		}
+		if entry.Expires == "" {
+			continue
+		}
+		entry.expires, err = time.Parse("2006-01-02", entry.Expires)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %s %s: %s", filename, entry.Rule, entry.Path, err)
+		}
+	}
+	return a, nil
+}
+
+// expired reports whether now is past the day the entry expires, in UTC.
+func (e *allowEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires.AddDate(0, 0, 1))
+}
+
+// filter returns the changes that no unexpired entry allows.
+func (a *allowlist) filter(changes []diff.Change, now time.Time) []diff.Change {
+	remaining := []diff.Change{}
+	for _, c := range changes {
+		allowed := false
+		for _, entry := range a.Allow {
+			if entry.Rule == c.Kind() && entry.Path == c.Path() && !entry.expired(now) {
+				entry.matched = true
+				allowed = true
+			}
+		}
+		if !allowed {
+			remaining = append(remaining, c)
+		}
+	}
+	return remaining
+}
+
+// problems describes entries that expired or that didn't match any change,
+// which should be removed from the allowlist.
+func (a *allowlist) problems(now time.Time) []string {
+	problems := []string{}
+	for _, entry := range a.Allow {
+		switch {
+		case entry.expired(now):
+			problems = append(problems, fmt.Sprintf("%s: allowed %s on '%s' expired on %s", a.filename, entry.Rule, entry.Path, entry.Expires))
+		case !entry.matched:
+			problems = append(problems, fmt.Sprintf("%s: allowed %s on '%s' doesn't match any change", a.filename, entry.Rule, entry.Path))
+		}
+	}
+	return problems
+}
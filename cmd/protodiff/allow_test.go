@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stackmachine/pb/diff"
+)
+
+func writeAllowlist(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "protodiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	filename := filepath.Join(dir, "allow.json")
+	if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestAllowlist(t *testing.T) {
+	filename := writeAllowlist(t, `{"allow": [
+		{"rule": "METHOD_REMOVED", "path": ".helloworld.Foo.Bar", "expires": "2018-06-30"},
+		{"rule": "SERVICE_REMOVED", "path": ".helloworld.Greeter", "expires": "2018-01-01"},
+		{"rule": "FIELD_REMOVED", "path": ".helloworld.HelloRequest.name"}
+	]}`)
+	a, err := loadAllowlist(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := []diff.Change{
+		diff.ProblemRemovedServiceMethod{Service: ".helloworld.Foo", Name: "Bar"},
+		diff.ProblemRemovedServiceMethod{Service: ".helloworld.Foo", Name: "Baz"},
+		diff.ProblemRemovedService{Name: ".helloworld.Greeter"},
+	}
+	// Entries apply until the end of the day they expire.
+	now := time.Date(2018, 6, 30, 23, 0, 0, 0, time.UTC)
+
+	remaining := a.filter(changes, now)
+	if len(remaining) != 2 {
+		t.Fatalf("expected only Foo.Bar to be allowed, got %v", remaining)
+	}
+	if remaining[0] != changes[1] || remaining[1] != changes[2] {
+		t.Errorf("expected the expired entry not to apply, got %v", remaining)
+	}
+
+	problems := a.problems(now)
+	expected := []string{
+		filename + ": allowed SERVICE_REMOVED on '.helloworld.Greeter' expired on 2018-01-01",
+		filename + ": allowed FIELD_REMOVED on '.helloworld.HelloRequest.name' doesn't match any change",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %q", len(expected), problems)
+	}
+	for i, problem := range expected {
+		if problems[i] != problem {
+			t.Errorf("expected problem: %s", problem)
+			t.Errorf("  actual problem: %s", problems[i])
+		}
+	}
+
+	if len(a.filter(changes, now.Add(time.Hour))) != 3 {
+		t.Errorf("expected entries to expire the day after their date")
+	}
+}
+
+func TestLoadAllowlistErrors(t *testing.T) {
+	tests := map[string]string{
+		"bad date":     `{"allow": [{"rule": "FIELD_REMOVED", "path": ".a.B.c", "expires": "30/06/2018"}]}`,
+		"missing path": `{"allow": [{"rule": "FIELD_REMOVED"}]}`,
+		"yaml":         "allow:\n  - rule: FIELD_REMOVED\n",
+	}
+	for name, contents := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := loadAllowlist(writeAllowlist(t, contents))
+			if err == nil || !strings.Contains(err.Error(), "error parsing") {
+				t.Errorf("expected a parse error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/stackmachine/pb/diff"
+)
+
+// severity returns the severity of a change relative to the breakage passed
+// to -mode: changes that break clients outside of mode are warnings.
+func severity(c diff.Change, mode diff.Breakage) diff.Severity {
+	switch {
+	case c.Breaks()&mode != 0:
+		return diff.SeverityError
+	case c.Breaks() != 0:
+		return diff.SeverityWarning
+	}
+	return diff.SeverityInfo
+}
+
+// formatters write changes in the formats accepted by -format.
+var formatters = map[string]func(w io.Writer, changes []diff.Change, mode diff.Breakage) error{
+	"text":  writeText,
+	"json":  writeJSON,
+	"sarif": writeSARIF,
+	"junit": writeJUnit,
+}
+
+func writeText(w io.Writer, changes []diff.Change, mode diff.Breakage) error {
+	for _, c := range changes {
+		var err error
+		if severity(c, mode) == diff.SeverityWarning {
+			_, err = fmt.Fprintf(w, "%s: warning: %s\n", c.Pos(), c)
+		} else {
+			_, err = fmt.Fprintf(w, "%s: %s\n", c.Pos(), c)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonChange is written for each change by -format json. Old and New are set
+// for changes that replace a value, such as the type of a field.
+type jsonChange struct {
+	Kind     diff.Kind `json:"kind"`
+	Severity string    `json:"severity"`
+	Path     string    `json:"path"`
+	Message  string    `json:"message"`
+	Breaks   []string  `json:"breaks"`
+	Old      *string   `json:"old,omitempty"`
+	New      *string   `json:"new,omitempty"`
+	File     string    `json:"file"`
+	Line     int       `json:"line,omitempty"`
+	Column   int       `json:"column,omitempty"`
+}
+
+// writeJSON writes one JSON object per line for each change.
+func writeJSON(w io.Writer, changes []diff.Change, mode diff.Breakage) error {
+	enc := json.NewEncoder(w)
+	for _, c := range changes {
+		breaks := []string{}
+		if c.Breaks() != 0 {
+			breaks = strings.Split(c.Breaks().String(), ",")
+		}
+		pos := c.Pos()
+		j := jsonChange{
+			Kind:     c.Kind(),
+			Severity: severity(c, mode).String(),
+			Path:     c.Path(),
+			Message:  c.String(),
+			Breaks:   breaks,
+			File:     pos.File,
+			Line:     pos.Line,
+			Column:   pos.Column,
+		}
+		if v, ok := c.(diff.ValueChange); ok {
+			prev, curr := v.Values()
+			j.Old, j.New = &prev, &curr
+		}
+		if err := enc.Encode(j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SARIF 2.1.0, as read by code scanning UIs. Only the properties protodiff
+// sets are declared.
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID diff.Kind `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID    diff.Kind       `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation  `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+var sarifLevels = map[diff.Severity]string{
+	diff.SeverityError:   "error",
+	diff.SeverityWarning: "warning",
+	diff.SeverityInfo:    "note",
+}
+
+func writeSARIF(w io.Writer, changes []diff.Change, mode diff.Breakage) error {
+	driver := sarifDriver{Name: "protodiff", Rules: []sarifRule{}}
+	results := []sarifResult{}
+	rules := map[diff.Kind]bool{}
+	for _, c := range changes {
+		if !rules[c.Kind()] {
+			rules[c.Kind()] = true
+			driver.Rules = append(driver.Rules, sarifRule{ID: c.Kind()})
+		}
+		pos := c.Pos()
+		loc := sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: pos.File}}
+		if pos.Line > 0 {
+			loc.Region = &sarifRegion{StartLine: pos.Line, StartColumn: pos.Column}
+		}
+		results = append(results, sarifResult{
+			RuleID:  c.Kind(),
+			Level:   sarifLevels[severity(c, mode)],
+			Message: sarifMessage{Text: c.String()},
+			Locations: []sarifLocation{{
+				PhysicalLocation: loc,
+				LogicalLocations: []sarifLogicalLocation{{FullyQualifiedName: c.Path()}},
+			}},
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}},
+	})
+}
+
+// JUnit XML, as read by test dashboards. Each change is a test case, which
+// fails if the change is an error.
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func writeJUnit(w io.Writer, changes []diff.Change, mode diff.Breakage) error {
+	suite := junitTestSuite{Name: "protodiff"}
+	for _, c := range changes {
+		tc := junitTestCase{Name: c.String(), ClassName: string(c.Kind())}
+		text := fmt.Sprintf("%s: %s", c.Pos(), c)
+		switch severity(c, mode) {
+		case diff.SeverityError:
+			tc.Failure = &junitFailure{Message: c.String(), Type: string(c.Kind()), Text: text}
+			suite.Failures++
+		case diff.SeverityWarning:
+			tc.SystemOut = "warning: " + text
+		default:
+			tc.SystemOut = text
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	// Dashboards show a suite without test cases as not run.
+	if len(suite.Cases) == 0 {
+		suite.Cases = append(suite.Cases, junitTestCase{Name: "no breaking changes", ClassName: "protodiff"})
+	}
+	suite.Tests = len(suite.Cases)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
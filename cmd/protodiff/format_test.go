@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/stackmachine/pb/diff"
+)
+
+// testChanges returns an error, a warning and an informational change when
+// diffing with -mode wire.
+func testChanges() []diff.Change {
+	return []diff.Change{
+		diff.ProblemChangedFieldType{
+			Position: diff.Position{File: "hello.proto", Line: 6, Column: 3},
+			Message:  ".helloworld.HelloRequest",
+			Number:   1,
+			Field:    "name",
+			OldType:  descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+			NewType:  descriptor.FieldDescriptorProto_TYPE_BOOL.Enum(),
+		},
+		diff.ProblemChangedFieldName{
+			Position: diff.Position{File: "hello.proto", Line: 7, Column: 3},
+			Message:  ".helloworld.HelloRequest",
+			Number:   2,
+			OldName:  proto.String("greeting"),
+			NewName:  proto.String("salutation"),
+		},
+		diff.AddedField{
+			Position: diff.Position{File: "hello.proto"},
+			Message:  ".helloworld.HelloRequest",
+			Field:    "count",
+		},
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, testChanges(), diff.BreaksWire); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected one line per change, got %q", buf.String())
+	}
+	var changes []map[string]interface{}
+	for _, line := range lines {
+		var c map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &c); err != nil {
+			t.Fatalf("error parsing %s: %s", line, err)
+		}
+		changes = append(changes, c)
+	}
+
+	expected := map[string]interface{}{
+		"kind":     "FIELD_TYPE_CHANGED",
+		"severity": "error",
+		"path":     ".helloworld.HelloRequest.name",
+		"old":      "TYPE_STRING",
+		"new":      "TYPE_BOOL",
+		"file":     "hello.proto",
+		"line":     float64(6),
+		"column":   float64(3),
+	}
+	for key, value := range expected {
+		if changes[0][key] != value {
+			t.Errorf("expected %s to be %v, got %v", key, value, changes[0][key])
+		}
+	}
+	if breaks, ok := changes[0]["breaks"].([]interface{}); !ok || len(breaks) != 3 {
+		t.Errorf("expected the type change to break wire, json and source, got %v", changes[0]["breaks"])
+	}
+	if changes[1]["severity"] != "warning" || changes[1]["old"] != "greeting" || changes[1]["new"] != "salutation" {
+		t.Errorf("expected a warning renaming greeting to salutation, got %v", changes[1])
+	}
+	if _, ok := changes[2]["old"]; ok || changes[2]["severity"] != "info" {
+		t.Errorf("expected an added field to be info without an old value, got %v", changes[2])
+	}
+	if _, ok := changes[2]["line"]; ok {
+		t.Errorf("expected an unknown line to be omitted, got %v", changes[2])
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeSARIF(&buf, testChanges(), diff.BreaksWire); err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("expected a single SARIF 2.1.0 run, got %s", buf.String())
+	}
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != 3 || len(run.Results) != 3 {
+		t.Fatalf("expected three rules and results, got %s", buf.String())
+	}
+	levels := []string{"error", "warning", "note"}
+	for i, result := range run.Results {
+		if result.Level != levels[i] {
+			t.Errorf("expected result %d to be a %s, got %s", i, levels[i], result.Level)
+		}
+	}
+	loc := run.Results[0].Locations[0]
+	if loc.PhysicalLocation.ArtifactLocation.URI != "hello.proto" || loc.PhysicalLocation.Region == nil || loc.PhysicalLocation.Region.StartLine != 6 {
+		t.Errorf("expected the type change at hello.proto:6, got %+v", loc.PhysicalLocation)
+	}
+	if loc.LogicalLocations[0].FullyQualifiedName != ".helloworld.HelloRequest.name" {
+		t.Errorf("expected the logical location to be the field, got %+v", loc.LogicalLocations)
+	}
+	if run.Results[2].Locations[0].PhysicalLocation.Region != nil {
+		t.Errorf("expected no region without a line")
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJUnit(&buf, testChanges(), diff.BreaksWire); err != nil {
+		t.Fatal(err)
+	}
+	var suite junitTestSuite
+	if err := xml.Unmarshal(buf.Bytes(), &suite); err != nil {
+		t.Fatal(err)
+	}
+	if suite.Tests != 3 || suite.Failures != 1 || len(suite.Cases) != 3 {
+		t.Fatalf("expected three tests and one failure, got %s", buf.String())
+	}
+	if suite.Cases[0].Failure == nil || suite.Cases[0].ClassName != "FIELD_TYPE_CHANGED" {
+		t.Errorf("expected the type change to fail, got %+v", suite.Cases[0])
+	}
+	if suite.Cases[1].Failure != nil || !strings.HasPrefix(suite.Cases[1].SystemOut, "warning: ") {
+		t.Errorf("expected the rename to pass with a warning, got %+v", suite.Cases[1])
+	}
+
+	buf.Reset()
+	if err := writeJUnit(&buf, nil, diff.BreaksWire); err != nil {
+		t.Fatal(err)
+	}
+	suite = junitTestSuite{}
+	if err := xml.Unmarshal(buf.Bytes(), &suite); err != nil {
+		t.Fatal(err)
+	}
+	if suite.Tests != 1 || suite.Failures != 0 {
+		t.Errorf("expected a passing test without changes, got %s", buf.String())
+	}
+}
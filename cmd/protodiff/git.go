@@ -0,0 +1,266 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/stackmachine/pb/diff"
+)
+
+// stringList is a flag that can be repeated, like protoc's -I.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// diffAgainst compares the working tree with the revision named by against,
+// e.g. "git:origin/main". files are either .proto files, which are compiled
+// with protoc on both sides, or a single checked-in FileDescriptorSet. Paths
+// are relative to the current directory.
+func diffAgainst(opts *diff.Options, against string, files, protoPaths []string) ([]diff.Change, error) {
+	rev := strings.TrimPrefix(against, "git:")
+	if rev == against || rev == "" {
+		return nil, fmt.Errorf("unsupported -against %q, expected git:<rev>", against)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("-against needs .proto files or a FileDescriptorSet to compare")
+	}
+
+	top, err := git("", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	prefix, err := git("", "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempDir("", "protodiff")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	r := revisionPaths{top: strings.TrimSpace(string(top)), src: filepath.Join(tmp, "src")}
+	prev := filepath.Join(tmp, "prev")
+
+	if !protoFiles(files) {
+		if len(files) != 1 {
+			return nil, errors.New("-against takes .proto files or a single FileDescriptorSet")
+		}
+		// git show resolves paths starting with ./ against the current
+		// directory, and other paths against the top-level directory.
+		name := "./" + filepath.ToSlash(files[0])
+		if rel, ok := r.rel(files[0]); ok {
+			name = filepath.ToSlash(rel)
+		}
+		blob, err := git("", "show", rev+":"+name)
+		if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(prev, blob, 0644); err != nil {
+			return nil, err
+		}
+		return diffFiles(opts, prev, files[0])
+	}
+
+	if err := checkoutProtos(r.top, rev, r.src); err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(r.src, strings.TrimSpace(string(prefix)))
+	prevPaths := []string{}
+	for _, path := range protoPaths {
+		path, err := r.protoPath(path)
+		if err != nil {
+			return nil, err
+		}
+		prevPaths = append(prevPaths, path)
+	}
+	// Files added since rev don't exist on the previous side.
+	existing := []string{}
+	for _, file := range files {
+		file = r.path(file)
+		if protoExists(dir, file, prevPaths) {
+			existing = append(existing, file)
+		}
+	}
+	if len(existing) == 0 {
+		// An empty file is an empty FileDescriptorSet.
+		err = ioutil.WriteFile(prev, nil, 0644)
+	} else {
+		err = protoc(dir, prev, existing, prevPaths)
+	}
+	if err != nil {
+		return nil, err
+	}
+	head := filepath.Join(tmp, "head")
+	if err := protoc("", head, files, protoPaths); err != nil {
+		return nil, err
+	}
+	return diffFiles(opts, prev, head)
+}
+
+// revisionPaths maps paths in the working tree of the repository at top to
+// the checkout of a revision in src.
+type revisionPaths struct {
+	top, src string
+}
+
+// rel returns an absolute path within the repository relative to top.
+// Relative paths and paths outside of the repository, such as system
+// includes, aren't mapped.
+func (r revisionPaths) rel(path string) (string, bool) {
+	if !filepath.IsAbs(path) {
+		return "", false
+	}
+	// git prints the top-level directory with symlinks resolved.
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	rel, err := filepath.Rel(r.top, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// path returns where path is found in the revision. Relative paths are
+// already resolved within the revision, as protoc runs in its checkout.
+func (r revisionPaths) path(path string) string {
+	if rel, ok := r.rel(path); ok {
+		return filepath.Join(r.src, rel)
+	}
+	return path
+}
+
+// protoPath returns where the import path is found in the revision. Unlike
+// files, relative import paths are resolved against the current directory,
+// as they may leave the repository, e.g. ../third_party. Paths outside of the
+// repository are returned as absolute paths.
+func (r revisionPaths) protoPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return r.path(abs), nil
+}
+
+// protoExists reports whether protoc running in dir finds file, either on
+// disk or within one of protoPaths.
+func protoExists(dir, file string, protoPaths []string) bool {
+	for _, path := range append([]string{""}, protoPaths...) {
+		path = filepath.Join(path, file)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func protoFiles(files []string) bool {
+	for _, file := range files {
+		if filepath.Ext(file) != ".proto" {
+			return false
+		}
+	}
+	return true
+}
+
+// git runs git in dir and returns its output. Errors from git are printed to
+// stderr.
+func git(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running git %s: %s", strings.Join(args, " "), err)
+	}
+	return out, nil
+}
+
+// checkoutProtos writes the .proto files of the repository at top as of rev
+// to dst, keeping their paths relative to top. The index and working tree are
+// left alone.
+func checkoutProtos(top, rev, dst string) error {
+	cmd := exec.Command("git", "archive", "--format=tar", rev)
+	cmd.Dir = top
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error running git archive %s: %s", rev, err)
+	}
+	if err := extractProtos(tar.NewReader(stdout), dst); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("error running git archive %s: %s", rev, err)
+	}
+	return nil
+}
+
+func extractProtos(r *tar.Reader, dst string) error {
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading git archive: %s", err)
+		}
+		if hdr.Typeflag != tar.TypeReg || filepath.Ext(hdr.Name) != ".proto" {
+			continue
+		}
+		path := filepath.Join(dst, filepath.FromSlash(hdr.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// protoc compiles files in dir to the FileDescriptorSet out, with the source
+// info protodiff uses to report positions.
+func protoc(dir, out string, files, protoPaths []string) error {
+	args := []string{"--include_imports", "--include_source_info", "-o", out}
+	for _, path := range protoPaths {
+		args = append(args, "--proto_path="+path)
+	}
+	cmd := exec.Command("protoc", append(args, files...)...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error running protoc %s: %s", strings.Join(files, " "), err)
+	}
+	return nil
+}
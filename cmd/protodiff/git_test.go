@@ -0,0 +1,209 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stackmachine/pb/diff"
+)
+
+// gitRepo creates a repository holding files, commits them, then changes the
+// working tree to hold changed. The current directory is the repository
+// until the test ends.
+//
+// Requires git and protoc to be installed.
+func gitRepo(t *testing.T, files, changed map[string]string) string {
+	dir, err := ioutil.TempDir("", "protodiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	// git prints the top-level directory with symlinks resolved.
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, files)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=protodiff", "-c", "user.email=protodiff@example.com", "commit", "-q", "-m", "previous"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s failed: %s %s", args[0], err, out)
+		}
+	}
+	writeFiles(t, dir, changed)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+const helloProto = `syntax = "proto3";
+
+package helloworld;
+
+import "common.proto";
+
+message HelloRequest {
+  Greeting greeting = 1;
+}
+`
+
+const commonProto = `syntax = "proto3";
+
+package helloworld;
+
+message Greeting {
+  string text = 1;
+  string language = 2;
+}
+`
+
+const changedCommonProto = `syntax = "proto3";
+
+package helloworld;
+
+message Greeting {
+  string text = 1;
+}
+`
+
+func expectChanges(t *testing.T, changes []diff.Change, err error, expected ...string) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %d: %v", len(expected), len(changes), changes)
+	}
+	for i, change := range expected {
+		if changes[i].String() != change {
+			t.Errorf("expected change: %s", change)
+			t.Errorf("  actual change: %s", changes[i].String())
+		}
+	}
+}
+
+func TestDiffAgainstProtos(t *testing.T) {
+	gitRepo(t, map[string]string{
+		"proto/hello.proto":  helloProto,
+		"proto/common.proto": commonProto,
+		"README.md":          "not a proto",
+	}, map[string]string{
+		"proto/common.proto": changedCommonProto,
+		"proto/added.proto":  "syntax = \"proto3\";\npackage helloworld;\nmessage Added {}\n",
+	})
+
+	changes, err := diffAgainst(&diff.Options{}, "git:HEAD", []string{"hello.proto", "added.proto"}, []string{"proto"})
+	expectChanges(t, changes, err,
+		"removed field 'language' from message '.helloworld.Greeting'",
+		"added file 'added.proto'",
+	)
+}
+
+// Absolute import paths within the repository must be read from the
+// revision, not from the working tree.
+func TestDiffAgainstAbsoluteProtoPath(t *testing.T) {
+	dir := gitRepo(t, map[string]string{
+		"proto/hello.proto":  helloProto,
+		"proto/common.proto": commonProto,
+	}, map[string]string{
+		"proto/common.proto": changedCommonProto,
+	})
+
+	protoDir := filepath.Join(dir, "proto")
+	changes, err := diffAgainst(&diff.Options{}, "git:HEAD", []string{filepath.Join(protoDir, "hello.proto")}, []string{protoDir})
+	expectChanges(t, changes, err,
+		"removed field 'language' from message '.helloworld.Greeting'",
+	)
+}
+
+// Relative import paths may leave the repository, and then must be read from
+// the working tree.
+func TestDiffAgainstRelativeProtoPath(t *testing.T) {
+	thirdParty, err := ioutil.TempDir("", "protodiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(thirdParty) })
+	writeFiles(t, thirdParty, map[string]string{"common.proto": commonProto})
+	dir := gitRepo(t, map[string]string{
+		"proto/hello.proto": strings.Replace(helloProto, "  Greeting greeting = 1;\n", "  Greeting greeting = 1;\n  string name = 2;\n", 1),
+	}, map[string]string{
+		"proto/hello.proto": helloProto,
+	})
+
+	rel, err := filepath.Rel(dir, thirdParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := diffAgainst(&diff.Options{}, "git:HEAD", []string{"hello.proto"}, []string{"proto", rel})
+	expectChanges(t, changes, err,
+		"removed field 'name' from message '.helloworld.HelloRequest'",
+	)
+}
+
+func TestDiffAgainstDescriptorSet(t *testing.T) {
+	dir := gitRepo(t, map[string]string{
+		"proto/hello.proto":  helloProto,
+		"proto/common.proto": commonProto,
+	}, nil)
+	compile := func() {
+		if err := protoc(filepath.Join(dir, "proto"), filepath.Join(dir, "hello.pb"), []string{"hello.proto"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	compile()
+	for _, args := range [][]string{
+		{"add", "hello.pb"},
+		{"-c", "user.name=protodiff", "-c", "user.email=protodiff@example.com", "commit", "-q", "-m", "descriptor set"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s failed: %s %s", args[0], err, out)
+		}
+	}
+	writeFiles(t, dir, map[string]string{"proto/common.proto": changedCommonProto})
+	compile()
+
+	changes, err := diffAgainst(&diff.Options{}, "git:HEAD", []string{"hello.pb"}, nil)
+	expectChanges(t, changes, err,
+		"removed field 'language' from message '.helloworld.Greeting'",
+	)
+	if _, err := diffAgainst(&diff.Options{}, "git:HEAD", []string{"missing.pb"}, nil); err == nil {
+		t.Error("expected an error for a descriptor set missing from the revision")
+	}
+}
+
+func TestDiffAgainstErrors(t *testing.T) {
+	if _, err := diffAgainst(&diff.Options{}, "svn:HEAD", []string{"hello.proto"}, nil); err == nil {
+		t.Error("expected an error for an unsupported revision")
+	}
+	if _, err := diffAgainst(&diff.Options{}, "git:HEAD", nil, nil); err == nil {
+		t.Error("expected an error without files")
+	}
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/protoc-gen-go/descriptor"
@@ -27,7 +29,38 @@ func parseFileDescriptorSet(filename string) (*descriptor.FileDescriptorSet, err
 	return &fds, nil
 }
 
-func diffFiles(previous, head string) ([]filechange, error) {
+// config is read from the file passed to -config, e.g.
+//
+//	{"options": {"(auth.scope)": "wire,json", "deprecated": "source"}}
+type config struct {
+	// Options maps option names to the breakage changing them causes, as
+	// accepted by -mode. It adds to and overrides diff.DefaultOptionPolicy.
+	Options map[string]string `json:"options"`
+}
+
+// loadConfig returns the default option policy with the entries of the
+// config file added.
+func loadConfig(filename string) (map[string]diff.Breakage, error) {
+	blob, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", filename, err)
+	}
+	var c config
+	if err := json.Unmarshal(blob, &c); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", filename, err)
+	}
+	policy := diff.DefaultOptionPolicy()
+	for option, s := range c.Options {
+		b, err := diff.ParseBreakage(s)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: option %s: %s", filename, option, err)
+		}
+		policy[option] = b
+	}
+	return policy, nil
+}
+
+func diffFiles(opts *diff.Options, previous, head string) ([]diff.Change, error) {
 	prev, err := parseFileDescriptorSet(previous)
 	if err != nil {
 		return nil, err
@@ -36,28 +69,21 @@ func diffFiles(previous, head string) ([]filechange, error) {
 	if err != nil {
 		return nil, err
 	}
-	report, err := diff.DiffSet(prev, curr)
-	fc := make([]filechange, len(report.Changes))
-	for i, c := range report.Changes {
-		fc[i] = filechange{filepath.Base(previous), c}
-	}
-	return fc, err
+	// The error from DiffSet only summarizes the report; the caller decides
+	// which changes are failures.
+	report, _ := opts.DiffSet(prev, curr)
+	return report.Changes, nil
 }
 
-type filechange struct {
-	file   string
-	change diff.Change
-}
-
-func diffDirs(previous, current string) ([]filechange, error) {
+func diffDirs(opts *diff.Options, previous, current string) ([]diff.Change, error) {
 	files, err := ioutil.ReadDir(previous)
 	if err != nil {
 		return nil, err
 	}
-	changes := []filechange{}
+	changes := []diff.Change{}
 	var lastErr error
 	for _, info := range files {
-		cs, err := diffFiles(filepath.Join(previous, info.Name()), filepath.Join(current, info.Name()))
+		cs, err := diffFiles(opts, filepath.Join(previous, info.Name()), filepath.Join(current, info.Name()))
 		changes = append(changes, cs...)
 		if err != nil {
 			lastErr = err
@@ -69,32 +95,98 @@ func diffDirs(previous, current string) ([]filechange, error) {
 // protoc -o old example.proto
 // protoc -o new example.proto
 // protodiff -prev old -head new
+// protodiff -mode wire -prev old -head new
+// protodiff -config protodiff.json -prev old -head new
+// protodiff -format sarif -prev old -head new > protodiff.sarif
+// protodiff -allow allow.json -prev old -head new
+// protodiff -against git:origin/main example.proto
+// protodiff -against git:origin/main example.pb
 func main() {
 	l = log.New(os.Stderr, "", 0)
 
-	var prevPath, headPath string
+	var prevPath, headPath, modeFlag, configPath, formatFlag, allowPath, against string
+	var protoPaths stringList
+	var changelog bool
 
 	flag.StringVar(&prevPath, "prev", "", "path to previous FileDescriptorSet file or directory")
 	flag.StringVar(&headPath, "head", "", "path to current FileDescriptorSet file or directory")
+	flag.StringVar(&modeFlag, "mode", "wire,json", "breakage to fail on: wire, json, source or all (comma separated)")
+	flag.StringVar(&configPath, "config", "", "path to a JSON file declaring which options break clients")
+	flag.StringVar(&formatFlag, "format", "text", "output format: text, json, sarif or junit")
+	flag.StringVar(&allowPath, "allow", "", "path to a JSON file listing breaking changes to accept (YAML isn't supported)")
+	flag.StringVar(&against, "against", "", "compare the working tree with a git revision, e.g. git:origin/main")
+	flag.Var(&protoPaths, "I", "directory to search for imports when compiling .proto files for -against (repeatable)")
+	flag.BoolVar(&changelog, "changelog", false, "also print changes that don't break clients, such as additions")
 	flag.Parse()
 
-	var changes []filechange
-	var err error
+	mode, err := diff.ParseBreakage(modeFlag)
+	if err != nil {
+		l.Fatal(err)
+	}
+	format, ok := formatters[formatFlag]
+	if !ok {
+		l.Fatalf("unknown format %q", formatFlag)
+	}
+	opts := &diff.Options{}
+	if configPath != "" {
+		if opts.OptionPolicy, err = loadConfig(configPath); err != nil {
+			l.Fatal(err)
+		}
+	}
+	var allowed *allowlist
+	if allowPath != "" {
+		if allowed, err = loadAllowlist(allowPath); err != nil {
+			l.Fatal(err)
+		}
+	}
 
-	if stat, serr := os.Stat(prevPath); serr == nil && stat.IsDir() {
-		changes, err = diffDirs(prevPath, headPath)
+	var changes []diff.Change
+
+	if against != "" {
+		changes, err = diffAgainst(opts, against, flag.Args(), protoPaths)
+	} else if stat, serr := os.Stat(prevPath); serr == nil && stat.IsDir() {
+		changes, err = diffDirs(opts, prevPath, headPath)
 	} else {
-		changes, err = diffFiles(prevPath, headPath)
+		changes, err = diffFiles(opts, prevPath, headPath)
 	}
 
-	if len(changes) > 0 {
-		for _, fc := range changes {
-			l.Printf("%s: %s\n", fc.file, fc.change)
+	failed := false
+	if allowed != nil {
+		now := time.Now()
+		changes = allowed.filter(changes, now)
+		for _, problem := range allowed.problems(now) {
+			l.Println(problem)
+			failed = true
 		}
-		os.Exit(1)
+	}
+
+	// Changes that only break clients outside of mode are warnings.
+	reported := []diff.Change{}
+	for _, c := range changes {
+		switch severity(c, mode) {
+		case diff.SeverityError:
+			failed = true
+		case diff.SeverityInfo:
+			if !changelog {
+				continue
+			}
+		}
+		reported = append(reported, c)
+	}
+
+	// Text is for people, other formats are written to stdout for tools.
+	out := os.Stdout
+	if formatFlag == "text" {
+		out = os.Stderr
+	}
+	if werr := format(out, reported, mode); werr != nil {
+		l.Fatal(werr)
 	}
 
 	if err != nil {
 		l.Fatal(err)
 	}
+	if failed {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,71 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Breakage is the set of ways in which a change breaks existing clients.
+type Breakage uint
+
+const (
+	// BreaksWire means messages encoded in the binary wire format by one
+	// version can't be read correctly by the other.
+	BreaksWire Breakage = 1 << iota
+	// BreaksJSON means messages encoded as JSON or in the text format by
+	// one version can't be read correctly by the other.
+	BreaksJSON
+	// BreaksSource means code using the generated code needs to change.
+	BreaksSource
+
+	BreaksAll = BreaksWire | BreaksJSON | BreaksSource
+)
+
+var breakageNames = []struct {
+	b    Breakage
+	name string
+}{
+	{BreaksWire, "wire"},
+	{BreaksJSON, "json"},
+	{BreaksSource, "source"},
+}
+
+func (b Breakage) String() string {
+	names := []string{}
+	for _, bn := range breakageNames {
+		if b&bn.b != 0 {
+			names = append(names, bn.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseBreakage parses a comma separated list of "wire", "json", "source",
+// "all" or "none".
+func ParseBreakage(s string) (Breakage, error) {
+	var b Breakage
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "all" {
+			b |= BreaksAll
+			continue
+		}
+		if name == "none" {
+			continue
+		}
+		found := false
+		for _, bn := range breakageNames {
+			if bn.name == name {
+				b |= bn.b
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown breakage %q", name)
+		}
+	}
+	return b, nil
+}
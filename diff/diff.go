@@ -2,7 +2,11 @@ package diff
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/protoc-gen-go/descriptor"
 	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
 	"github.com/google/go-cmp/cmp"
@@ -15,6 +19,8 @@ import (
 // - Changing the input or output message type
 // - Nesting / Unnesting a message or enum type
 // - Looking at options is important too
+// - Adding a required field, or changing whether a proto2 field is required
+// - Changing the default value of a proto2 field
 
 // Things that would require code changes
 // - What if they change the java package name?
@@ -23,7 +29,8 @@ import (
 // - Marking a field as repeated
 
 // There are two types of changes: ones that will break existing clients, and
-// ones that will require new code changes
+// ones that will require new code changes. Breakage tells them apart, and
+// splits client breakage into the binary and JSON encodings.
 
 // This package works by manually checking for differences
 // We could instead write a general comparison algorithm that diffs two golang structs
@@ -34,215 +41,990 @@ import (
 //   Ident: String (filename, message name, etc)
 type Change interface {
 	String() string
+	Pos() Position
+	Breaks() Breakage
+	// Kind identifies the type of change, e.g. KindFieldRemoved. Unlike
+	// String, it doesn't change between releases.
+	Kind() Kind
+	// Path is the fully qualified name of the changed element, e.g.
+	// ".helloworld.HelloRequest.name", or the file name for changes to a
+	// file. Enum values are named within their enum.
+	Path() string
+}
+
+// ValueChange is implemented by changes that replace one value with another,
+// such as the type of a field or the value of an option. Values returns the
+// previous and current values, formatted as in String.
+type ValueChange interface {
+	Change
+	Values() (string, string)
 }
 
 type Report struct {
 	Changes []Change
+	// policy decides how the option changes added to the report break
+	// clients.
+	policy map[string]Breakage
 }
 
 func (r *Report) Add(ch Change) {
 	r.Changes = append(r.Changes, ch)
 }
 
+// Options configure a diff. The zero value diffs with the default policy.
+type Options struct {
+	// OptionPolicy maps option names to how changing them breaks clients.
+	// If nil, DefaultOptionPolicy is used. To add or override entries,
+	// start from a copy of the default policy.
+	OptionPolicy map[string]Breakage
+}
+
+// Diff compares the files of two requests using the default options.
 func Diff(previous, current *plugin.CodeGeneratorRequest) (*Report, error) {
-	curr := map[string]*descriptor.FileDescriptorProto{}
-	report := &Report{Changes: []Change{}}
+	return (&Options{}).Diff(previous, current)
+}
+
+// DiffSet compares two descriptor sets using the default options.
+func DiffSet(previous, current *descriptor.FileDescriptorSet) (*Report, error) {
+	return (&Options{}).DiffSet(previous, current)
+}
+
+func (o *Options) Diff(previous, current *plugin.CodeGeneratorRequest) (*Report, error) {
+	report := o.report()
+	diffFiles(report, previous.ProtoFile, current.ProtoFile)
+	return report, report.err()
+}
 
-	for _, protoFile := range current.ProtoFile {
+func (o *Options) DiffSet(previous, current *descriptor.FileDescriptorSet) (*Report, error) {
+	report := o.report()
+	diffFiles(report, previous.File, current.File)
+	return report, report.err()
+}
+
+func (o *Options) report() *Report {
+	report := &Report{Changes: []Change{}, policy: o.OptionPolicy}
+	if report.policy == nil {
+		report.policy = defaultOptionPolicy
+	}
+	return report
+}
+
+// Breaking returns the changes that break clients in any of the ways in b.
+func (r *Report) Breaking(b Breakage) []Change {
+	changes := []Change{}
+	for _, ch := range r.Changes {
+		if ch.Breaks()&b != 0 {
+			changes = append(changes, ch)
+		}
+	}
+	return changes
+}
+
+// err returns an error summarizing the changes in the report that break the
+// binary or JSON encodings, or nil if there are none. Changes that only break
+// generated code are warnings, as with Breakage.Severity.
+func (r *Report) err() error {
+	problems := r.Breaking(BreaksWire | BreaksJSON)
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("found %d problems: %s", len(problems), problems)
+}
+
+// diffFiles matches files by name and diffs each pair. Files that only exist
+// on one side are checked for a rename before being reported as removed or
+// added.
+func diffFiles(report *Report, previous, current []*descriptor.FileDescriptorProto) {
+	prevSet, currSet := newFileSet(previous), newFileSet(current)
+	curr := map[string]*descriptor.FileDescriptorProto{}
+	for _, protoFile := range current {
 		curr[*protoFile.Name] = protoFile
 	}
 
-	for _, protoFile := range previous.ProtoFile {
+	removed := []*descriptor.FileDescriptorProto{}
+	for _, protoFile := range previous {
 		next, exists := curr[*protoFile.Name]
 		if !exists {
-			report.Add(ProblemRemovedFile{*protoFile.Name})
+			removed = append(removed, protoFile)
+			continue
+		}
+		delete(curr, *protoFile.Name)
+		diffFile(report, fileScope(prevSet, protoFile), fileScope(currSet, next), protoFile, next)
+	}
+
+	for _, protoFile := range removed {
+		next := findRenamedFile(protoFile, current, curr)
+		if next == nil {
+			report.Add(ProblemRemovedFile{Position{File: *protoFile.Name}})
+			removedFile(report, fileScope(prevSet, protoFile), protoFile)
 			continue
 		}
-		diffFile(report, protoFile, next)
+		delete(curr, *next.Name)
+		report.Add(ProblemRenamedFile{
+			Position: Position{File: *next.Name},
+			OldName:  *protoFile.Name,
+			NewName:  *next.Name,
+		})
+		diffFile(report, fileScope(prevSet, protoFile), fileScope(currSet, next), protoFile, next)
 	}
 
-	var err error
-	if len(report.Changes) > 0 {
-		err = fmt.Errorf("found %d problems: %s", len(report.Changes), report.Changes)
+	for _, protoFile := range current {
+		if _, added := curr[*protoFile.Name]; added {
+			report.Add(AddedFile{Position{File: *protoFile.Name}})
+		}
 	}
 
-	return report, err
+	findMovedTypes(report, prevSet, currSet)
 }
 
-func DiffSet(previous, current *descriptor.FileDescriptorSet) (*Report, error) {
-	report := &Report{Changes: []Change{}}
-	// TODO: Figure out how we want to deal with name changes
-	diffFile(report, previous.File[0], current.File[0])
-	var err error
-	if len(report.Changes) > 0 {
-		err = fmt.Errorf("found %d problems: %s", len(report.Changes), report.Changes)
+// removedFile reports the extensions, enums, services and messages declared
+// in a removed file, which clients lose along with the file.
+func removedFile(report *Report, scope element, file *descriptor.FileDescriptorProto) {
+	diffExtensions(report, scope, scope, file.Extension, nil, fileExtensionPath)
+	for i, enum := range file.EnumType {
+		prev := scope.child(*enum.Name, fileEnumTypePath, int32(i))
+		report.Add(ProblemRemovedEnum{Position: prev.pos(), Enum: prev.name})
+	}
+	for i, srv := range file.Service {
+		prev := scope.child(*srv.Name, fileServicePath, int32(i))
+		report.Add(ProblemRemovedService{Position: prev.pos(), Name: prev.name})
+	}
+	for i, msg := range file.MessageType {
+		prev := scope.child(*msg.Name, fileMessageTypePath, int32(i))
+		report.Add(ProblemRemovedMessage{Position: prev.pos(), Message: prev.name})
+	}
+}
+
+// findRenamedFile returns the unmatched file in current that shares a package
+// and the most top-level declarations with previous, or nil if there is none.
+func findRenamedFile(previous *descriptor.FileDescriptorProto, current []*descriptor.FileDescriptorProto, unmatched map[string]*descriptor.FileDescriptorProto) *descriptor.FileDescriptorProto {
+	decls := topLevelNames(previous)
+	var best *descriptor.FileDescriptorProto
+	bestScore := 0
+	for _, candidate := range current {
+		if _, ok := unmatched[*candidate.Name]; !ok {
+			continue
+		}
+		if !cmp.Equal(previous.Package, candidate.Package) {
+			continue
+		}
+		score := 0
+		for name := range topLevelNames(candidate) {
+			if decls[name] {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	return best
+}
+
+func topLevelNames(file *descriptor.FileDescriptorProto) map[string]bool {
+	names := map[string]bool{}
+	for _, msg := range file.MessageType {
+		names[*msg.Name] = true
+	}
+	for _, enum := range file.EnumType {
+		names[*enum.Name] = true
+	}
+	for _, srv := range file.Service {
+		names[*srv.Name] = true
+	}
+	return names
+}
+
+// findRenamedService returns the index of the unmatched service in current
+// that shares the most methods with previous, or -1 if none shares at least
+// half of them.
+func findRenamedService(previous *descriptor.ServiceDescriptorProto, current []*descriptor.ServiceDescriptorProto, unmatched map[string]int) int {
+	best, bestScore := -1, 0
+	for j, candidate := range current {
+		if _, ok := unmatched[*candidate.Name]; !ok {
+			continue
+		}
+		score := len(sharedMethods(previous, candidate))
+		if score > bestScore && score*2 >= len(previous.Method) {
+			best, bestScore = j, score
+		}
+	}
+	return best
+}
+
+// sharedMethods returns the names of the methods of previous that current
+// also declares.
+func sharedMethods(previous, current *descriptor.ServiceDescriptorProto) []string {
+	names := map[string]bool{}
+	for _, method := range current.Method {
+		names[method.GetName()] = true
+	}
+	shared := []string{}
+	for _, method := range previous.Method {
+		if names[method.GetName()] {
+			shared = append(shared, method.GetName())
+		}
+	}
+	return shared
+}
+
+// grpcMethod returns the full method name gRPC uses as the HTTP/2 path of a
+// method, e.g. "/helloworld.Greeter/SayHello".
+func grpcMethod(service, method string) string {
+	return "/" + strings.TrimPrefix(service, ".") + "/" + method
+}
+
+// element is a declaration in a specific file, identified by its fully
+// qualified proto name, e.g. ".helloworld.HelloRequest", and by its path in
+// the file's SourceCodeInfo. set holds the declarations it can refer to.
+type element struct {
+	set  *fileSet
+	file *descriptor.FileDescriptorProto
+	name string
+	path []int32
+}
+
+// child returns the element for a declaration nested inside e. path is the
+// field number and index of the declaration within e's descriptor.
+func (e element) child(name string, path ...int32) element {
+	return element{
+		set:  e.set,
+		file: e.file,
+		name: e.name + "." + name,
+		path: append(append([]int32{}, e.path...), path...),
 	}
-	return report, err
 }
 
-func diffFile(report *Report, previous, current *descriptor.FileDescriptorProto) {
+// pos returns the position of e, or of the declaration at path below e. Line
+// and column are only known if the file was compiled with
+// --include_source_info.
+func (e element) pos(path ...int32) Position {
+	pos := e.set.positions(e.file)[pathKey(e.path, path)]
+	pos.File = e.file.GetName()
+	return pos
+}
+
+// pathKey joins SourceCodeInfo paths into a key for fileSet.positions.
+func pathKey(paths ...[]int32) string {
+	key := []byte{}
+	for _, path := range paths {
+		for _, n := range path {
+			key = strconv.AppendInt(key, int64(n), 10)
+			key = append(key, ',')
+		}
+	}
+	return string(key)
+}
+
+// fileScope returns the element for the package scope of a file in set.
+func fileScope(set *fileSet, file *descriptor.FileDescriptorProto) element {
+	scope := element{set: set, file: file}
+	if file.GetPackage() != "" {
+		scope.name = "." + file.GetPackage()
+	}
+	return scope
+}
+
+// Field numbers used to build SourceCodeInfo paths.
+const (
+	filePackagePath       = 2
+	fileMessageTypePath   = 4
+	fileEnumTypePath      = 5
+	fileServicePath       = 6
+	fileExtensionPath     = 7
+	fileSyntaxPath        = 12
+	msgFieldPath          = 2
+	msgNestedTypePath     = 3
+	msgEnumTypePath       = 4
+	msgExtensionRangePath = 5
+	msgExtensionPath      = 6
+	msgOneofDeclPath      = 8
+	msgReservedRangePath  = 9
+	msgReservedNamePath   = 10
+	enumValuePath         = 2
+	enumReservedRangePath = 4
+	enumReservedNamePath  = 5
+	serviceMethodPath     = 2
+)
+
+func diffFile(report *Report, prevScope, currScope element, previous, current *descriptor.FileDescriptorProto) {
 	{ // Name and package
 		if !cmp.Equal(previous.Package, current.Package) {
 			report.Add(ProblemChangedPackage{
-				File:   current,
-				OldPkg: *previous.Package,
-				NewPkg: *current.Package,
+				Position: currScope.pos(filePackagePath),
+				OldPkg:   previous.GetPackage(),
+				NewPkg:   current.GetPackage(),
+			})
+		}
+		if syntax(previous) != syntax(current) {
+			report.Add(ProblemChangedSyntax{
+				Position:  currScope.pos(fileSyntaxPath),
+				OldSyntax: syntax(previous),
+				NewSyntax: syntax(current),
 			})
 		}
 	}
 
+	diffOptions(report, prevScope, currScope, current.GetName(), previous.Options, current.Options)
+	diffExtensions(report, prevScope, currScope, previous.Extension, current.Extension, fileExtensionPath)
+
 	{ // EnumType
-		curr := map[string]*descriptor.EnumDescriptorProto{}
-		for _, enum := range current.EnumType {
-			curr[*enum.Name] = enum
+		curr := map[string]int{}
+		for i, enum := range current.EnumType {
+			curr[*enum.Name] = i
 		}
-		for _, enum := range previous.EnumType {
-			next, exists := curr[*enum.Name]
+		for i, enum := range previous.EnumType {
+			prev := prevScope.child(*enum.Name, fileEnumTypePath, int32(i))
+			j, exists := curr[*enum.Name]
 			if !exists {
-				report.Add(ProblemRemovedEnum{*enum.Name})
+				report.Add(ProblemRemovedEnum{Position: prev.pos(), Enum: prev.name})
 				continue
 			}
-			diffEnum(report, enum, next)
+			delete(curr, *enum.Name)
+			next := current.EnumType[j]
+			diffEnum(report, prev, currScope.child(*next.Name, fileEnumTypePath, int32(j)), enum, next)
+		}
+		for j, enum := range current.EnumType {
+			if _, added := curr[*enum.Name]; added {
+				next := currScope.child(*enum.Name, fileEnumTypePath, int32(j))
+				report.Add(AddedEnum{Position: next.pos(), Enum: next.name})
+			}
 		}
 	}
 
 	{ // Service
-		curr := map[string]*descriptor.ServiceDescriptorProto{}
-		for _, srv := range current.Service {
-			curr[*srv.Name] = srv
+		curr := map[string]int{}
+		for i, srv := range current.Service {
+			curr[*srv.Name] = i
 		}
-		for _, srv := range previous.Service {
-			next, exists := curr[*srv.Name]
+		removed := []int{}
+		for i, srv := range previous.Service {
+			prev := prevScope.child(*srv.Name, fileServicePath, int32(i))
+			j, exists := curr[*srv.Name]
 			if !exists {
-				report.Add(ProblemRemovedService{*srv.Name})
+				removed = append(removed, i)
 				continue
 			}
-			diffService(report, srv, next)
+			delete(curr, *srv.Name)
+			next := current.Service[j]
+			diffService(report, prev, currScope.child(*next.Name, fileServicePath, int32(j)), srv, next)
+		}
+		for _, i := range removed {
+			srv := previous.Service[i]
+			prev := prevScope.child(*srv.Name, fileServicePath, int32(i))
+			j := findRenamedService(srv, current.Service, curr)
+			if j < 0 {
+				report.Add(ProblemRemovedService{Position: prev.pos(), Name: prev.name})
+				continue
+			}
+			next := current.Service[j]
+			delete(curr, *next.Name)
+			currSrv := currScope.child(*next.Name, fileServicePath, int32(j))
+			renamed := ProblemRenamedService{Position: currSrv.pos(), OldName: prev.name, NewName: currSrv.name}
+			for _, method := range sharedMethods(srv, next) {
+				renamed.OldMethods = append(renamed.OldMethods, grpcMethod(prev.name, method))
+				renamed.NewMethods = append(renamed.NewMethods, grpcMethod(currSrv.name, method))
+			}
+			report.Add(renamed)
+			diffService(report, prev, currSrv, srv, next)
+		}
+		for j, srv := range current.Service {
+			if _, added := curr[*srv.Name]; added {
+				next := currScope.child(*srv.Name, fileServicePath, int32(j))
+				report.Add(AddedService{Position: next.pos(), Name: next.name})
+			}
 		}
 	}
 
 	{ // MessageType
-		curr := map[string]*descriptor.DescriptorProto{}
-		for _, msg := range current.MessageType {
-			curr[*msg.Name] = msg
+		curr := map[string]int{}
+		for i, msg := range current.MessageType {
+			curr[*msg.Name] = i
 		}
-		for _, msg := range previous.MessageType {
-			next, exists := curr[*msg.Name]
+		for i, msg := range previous.MessageType {
+			prev := prevScope.child(*msg.Name, fileMessageTypePath, int32(i))
+			j, exists := curr[*msg.Name]
 			if !exists {
-				report.Add(ProblemRemovedMessage{*msg.Name})
+				report.Add(ProblemRemovedMessage{Position: prev.pos(), Message: prev.name})
 				continue
 			}
-			diffMsg(report, msg, next)
+			delete(curr, *msg.Name)
+			next := current.MessageType[j]
+			diffMsg(report, prev, currScope.child(*next.Name, fileMessageTypePath, int32(j)), msg, next)
+		}
+		for j, msg := range current.MessageType {
+			if _, added := curr[*msg.Name]; added {
+				next := currScope.child(*msg.Name, fileMessageTypePath, int32(j))
+				report.Add(AddedMessage{Position: next.pos(), Message: next.name})
+			}
 		}
 	}
 }
 
-func diffMsg(report *Report, previous, current *descriptor.DescriptorProto) {
-	curr := map[int32]*descriptor.FieldDescriptorProto{}
+// diffMsg compares the fields of a message, then recurses into its nested
+// messages and enums. prevMsg and currMsg identify the message on each side.
+func diffMsg(report *Report, prevMsg, currMsg element, previous, current *descriptor.DescriptorProto) {
+	diffOptions(report, prevMsg, currMsg, currMsg.name, previous.Options, current.Options)
+	diffExtensionRanges(report, prevMsg, currMsg, previous, current)
+	diffExtensions(report, prevMsg, currMsg, previous.Extension, current.Extension, msgExtensionPath)
+	oneofs := diffOneofs(report, prevMsg, currMsg, previous, current)
+	reserved := messageReservations(current)
+	curr := map[int32]int{}
+	fields := []declared{}
 
-	for _, field := range current.Field {
-		curr[*field.Number] = field
+	for i, field := range current.Field {
+		curr[*field.Number] = i
+		fields = append(fields, declared{*field.Number, *field.Name, i})
 	}
+	diffReservations(report, prevMsg, currMsg, messageReservations(previous), reserved, fields, msgFieldPath)
 
-	for _, field := range previous.Field {
-		next, exists := curr[*field.Number]
+	for i, field := range previous.Field {
+		j, exists := curr[*field.Number]
 		if !exists {
-			report.Add(ProblemRemovedField{*current.Name, *field.Name})
+			report.Add(ProblemRemovedField{
+				Position:       prevMsg.pos(msgFieldPath, int32(i)),
+				Message:        prevMsg.name,
+				Field:          *field.Name,
+				NumberReserved: reserved.hasNumber(*field.Number),
+				NameReserved:   reserved.hasName(*field.Name),
+			})
 			continue
 		}
-		if !cmp.Equal(field.Name, next.Name) {
-			report.Add(ProblemChangedFieldName{
-				Message: *current.Name,
-				Number:  *field.Number,
-				OldName: field.Name,
-				NewName: next.Name,
-			})
+		delete(curr, *field.Number)
+		next := current.Field[j]
+		currField := currMsg.child(*next.Name, msgFieldPath, int32(j))
+		diffField(report, prevMsg, currMsg, currField, previous, current, oneofs, field, next)
+	}
+
+	for j, field := range current.Field {
+		if _, added := curr[*field.Number]; !added {
+			continue
+		}
+		if field.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REQUIRED {
+			report.Add(ProblemAddedRequiredField{Position: currMsg.pos(msgFieldPath, int32(j)), Message: currMsg.name, Field: *field.Name})
+			continue
+		}
+		report.Add(AddedField{Position: currMsg.pos(msgFieldPath, int32(j)), Message: currMsg.name, Field: *field.Name})
+	}
+
+	{ // NestedType
+		// Map entries are compared with their map fields.
+		curr := map[string]int{}
+		for i, nested := range current.NestedType {
+			if !nested.GetOptions().GetMapEntry() {
+				curr[*nested.Name] = i
+			}
+		}
+		for i, nested := range previous.NestedType {
+			if nested.GetOptions().GetMapEntry() {
+				continue
+			}
+			prev := prevMsg.child(*nested.Name, msgNestedTypePath, int32(i))
+			j, exists := curr[*nested.Name]
+			if !exists {
+				report.Add(ProblemRemovedMessage{Position: prev.pos(), Message: prev.name})
+				continue
+			}
+			delete(curr, *nested.Name)
+			next := current.NestedType[j]
+			diffMsg(report, prev, currMsg.child(*next.Name, msgNestedTypePath, int32(j)), nested, next)
+		}
+		for j, nested := range current.NestedType {
+			if _, added := curr[*nested.Name]; added {
+				next := currMsg.child(*nested.Name, msgNestedTypePath, int32(j))
+				report.Add(AddedMessage{Position: next.pos(), Message: next.name})
+			}
 		}
+	}
+
+	{ // EnumType
+		curr := map[string]int{}
+		for i, enum := range current.EnumType {
+			curr[*enum.Name] = i
+		}
+		for i, enum := range previous.EnumType {
+			prev := prevMsg.child(*enum.Name, msgEnumTypePath, int32(i))
+			j, exists := curr[*enum.Name]
+			if !exists {
+				report.Add(ProblemRemovedEnum{Position: prev.pos(), Enum: prev.name})
+				continue
+			}
+			delete(curr, *enum.Name)
+			next := current.EnumType[j]
+			diffEnum(report, prev, currMsg.child(*next.Name, msgEnumTypePath, int32(j)), enum, next)
+		}
+		for j, enum := range current.EnumType {
+			if _, added := curr[*enum.Name]; added {
+				next := currMsg.child(*enum.Name, msgEnumTypePath, int32(j))
+				report.Add(AddedEnum{Position: next.pos(), Enum: next.name})
+			}
+		}
+	}
+}
+
+// diffField compares a field in previous with the field in current that has
+// the same number. currField is next as an element of currMsg. oneofs maps the
+// oneofs of previous to those of current.
+func diffField(report *Report, prevMsg, currMsg, currField element, previous, current *descriptor.DescriptorProto, oneofs map[string]string, field, next *descriptor.FieldDescriptorProto) {
+	if reusedNumber(current, field, next) {
+		report.Add(ProblemReusedFieldNumber{
+			Position: currField.pos(),
+			Message:  currMsg.name,
+			Number:   *field.Number,
+			OldField: *field.Name,
+			NewField: *next.Name,
+			OldType:  fieldTypeName(field),
+			NewType:  fieldTypeName(next),
+		})
+		return
+	}
+	if !cmp.Equal(field.Name, next.Name) {
+		report.Add(ProblemChangedFieldName{
+			Position: currField.pos(),
+			Message:  currMsg.name,
+			Number:   *field.Number,
+			OldName:  field.Name,
+			NewName:  next.Name,
+		})
+	}
+	// Renames already break JSON clients.
+	if cmp.Equal(field.Name, next.Name) && jsonName(field) != jsonName(next) {
+		report.Add(ProblemChangedFieldJSONName{
+			Position:    currField.pos(),
+			Message:     currMsg.name,
+			Field:       *next.Name,
+			OldJSONName: jsonName(field),
+			NewJSONName: jsonName(next),
+		})
+	}
+	isMap := mapEntry(prevMsg.set, field) != nil || mapEntry(currMsg.set, next) != nil
+	if isMap {
+		diffMapField(report, prevMsg, currMsg, currField, field, next)
+	} else {
 		if !cmp.Equal(field.Type, next.Type) {
 			report.Add(ProblemChangedFieldType{
-				Message: *current.Name,
-				Field:   *field.Name,
-				OldType: field.Type,
-				NewType: next.Type,
+				Position: currField.pos(),
+				Message:  currMsg.name,
+				Number:   *field.Number,
+				Field:    *field.Name,
+				OldType:  field.Type,
+				NewType:  next.Type,
 			})
 		}
-		if !cmp.Equal(field.Label, next.Label) {
-			report.Add(ProblemChangedFieldLabel{
-				Message:  *current.Name,
-				Field:    *field.Name,
-				OldLabel: field.Label,
-				NewLabel: next.Label,
+		if cmp.Equal(field.Type, next.Type) && !cmp.Equal(field.TypeName, next.TypeName) {
+			report.Add(ProblemChangedFieldTypeName{
+				Position:     currField.pos(),
+				Message:      currMsg.name,
+				Field:        *field.Name,
+				OldTypeName:  field.GetTypeName(),
+				NewTypeName:  next.GetTypeName(),
+				TypeBreakage: TypeBreakage{Breakage: compareTypes(prevMsg.set, currMsg.set, field.GetTypeName(), next.GetTypeName())},
 			})
 		}
+	}
+	oldOneof, newOneof := oneofName(previous, field), oneofName(current, next)
+	if expected, ok := oneofs[oldOneof]; !ok || expected != newOneof {
+		report.Add(ProblemChangedFieldOneof{
+			Position: currField.pos(),
+			Message:  currMsg.name,
+			Field:    *field.Name,
+			OldOneof: oldOneof,
+			NewOneof: newOneof,
+		})
+	}
+	if required(field) != required(next) && !repeated(field) && !repeated(next) {
+		report.Add(ProblemChangedFieldRequired{
+			Position: currField.pos(),
+			Message:  currMsg.name,
+			Field:    *field.Name,
+			Required: required(next),
+		})
+	} else if !cmp.Equal(field.Label, next.Label) && !isMap {
+		report.Add(ProblemChangedFieldLabel{
+			Position: currField.pos(),
+			Message:  currMsg.name,
+			Field:    *field.Name,
+			OldLabel: field.Label,
+			NewLabel: next.Label,
+		})
+	}
+	// Without an explicit default, enum fields default to the first value of
+	// the enum, whose changes diffEnum reports.
+	implicitEnum := field.GetType() == descriptor.FieldDescriptorProto_TYPE_ENUM && field.DefaultValue == nil && next.DefaultValue == nil
+	if cmp.Equal(field.Type, next.Type) && cmp.Equal(field.TypeName, next.TypeName) && !repeated(field) && !repeated(next) && !implicitEnum {
+		oldDefault, oldKey := defaultValue(prevMsg.set, field)
+		newDefault, newKey := defaultValue(currMsg.set, next)
+		if oldKey != newKey {
+			report.Add(ProblemChangedFieldDefault{
+				Position:   currField.pos(),
+				Message:    currMsg.name,
+				Field:      *field.Name,
+				OldDefault: oldDefault,
+				NewDefault: newDefault,
+			})
+		}
+	}
+	diffOptions(report, prevMsg, currField, currField.name, fieldOptions(prevMsg.file, field), fieldOptions(currMsg.file, next))
+}
+
+// syntax returns the syntax of file, which is proto2 unless declared.
+func syntax(file *descriptor.FileDescriptorProto) string {
+	if file.GetSyntax() == "" {
+		return "proto2"
+	}
+	return file.GetSyntax()
+}
+
+func required(field *descriptor.FieldDescriptorProto) bool {
+	return field.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REQUIRED
+}
+
+func repeated(field *descriptor.FieldDescriptorProto) bool {
+	return field.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED
+}
+
+// defaultValue returns the value readers see when field is missing from a
+// message, and the key to compare it by. Only proto2 fields declare defaults;
+// other fields default to their zero value. Strings and bytes are quoted.
+// Enum values are compared by number, so renaming the default value doesn't
+// change it. Message fields have no default.
+func defaultValue(set *fileSet, field *descriptor.FieldDescriptorProto) (string, string) {
+	value := field.GetDefaultValue()
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE, descriptor.FieldDescriptorProto_TYPE_GROUP:
+		return "", ""
+	case descriptor.FieldDescriptorProto_TYPE_STRING, descriptor.FieldDescriptorProto_TYPE_BYTES:
+		value = strconv.Quote(value)
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		if field.DefaultValue == nil {
+			value = "false"
+		}
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		enum, ok := set.enums[field.GetTypeName()]
+		if !ok || len(enum.Value) == 0 {
+			return value, value
+		}
+		// The default is the first value of the enum unless declared.
+		if field.DefaultValue == nil {
+			value = enum.Value[0].GetName()
+		}
+		for _, v := range enum.Value {
+			if v.GetName() == value {
+				return value, strconv.Itoa(int(v.GetNumber()))
+			}
+		}
+	default:
+		if field.DefaultValue == nil {
+			value = "0"
+		}
+	}
+	return value, value
+}
 
+// reusedNumber reports whether next looks like a different field that took
+// over the number of field, rather than field being renamed.
+func reusedNumber(current *descriptor.DescriptorProto, field, next *descriptor.FieldDescriptorProto) bool {
+	if field.GetName() == next.GetName() {
+		return false
 	}
+	// The old name moved to a different number.
+	for _, f := range current.Field {
+		if f.GetName() == field.GetName() {
+			return true
+		}
+	}
+	// Keeping the JSON name is a sign of a careful rename.
+	if jsonName(field) == jsonName(next) {
+		return false
+	}
+	// Renames keep the type.
+	return field.GetType() != next.GetType() || field.GetTypeName() != next.GetTypeName()
 }
 
-func diffEnum(report *Report, previous, current *descriptor.EnumDescriptorProto) {
-	byvalue := map[int32]*descriptor.EnumValueDescriptorProto{}
-	byname := map[string]*descriptor.EnumValueDescriptorProto{}
+// fieldTypeName returns the type of a field as written in a .proto file,
+// e.g. "string" or ".helloworld.HelloRequest".
+func fieldTypeName(field *descriptor.FieldDescriptorProto) string {
+	if field.GetTypeName() != "" {
+		return field.GetTypeName()
+	}
+	return strings.ToLower(strings.TrimPrefix(field.GetType().String(), "TYPE_"))
+}
 
-	for _, value := range current.Value {
-		byvalue[*value.Number] = value
+// diffOneofs matches the oneofs of a message by name, or by their fields if
+// they were renamed. It returns the name of each previous oneof in current;
+// removed oneofs are missing. Fields outside of a oneof map "" to "".
+func diffOneofs(report *Report, prevMsg, currMsg element, previous, current *descriptor.DescriptorProto) map[string]string {
+	matched := map[string]string{"": ""}
+	curr := map[string]int{}
+	for j, oneof := range current.OneofDecl {
+		if !syntheticOneof(current, int32(j)) {
+			curr[oneof.GetName()] = j
+		}
 	}
 
-	for _, value := range current.Value {
-		byname[*value.Name] = value
+	removed := []int{}
+	for i, oneof := range previous.OneofDecl {
+		if syntheticOneof(previous, int32(i)) {
+			continue
+		}
+		if _, exists := curr[oneof.GetName()]; !exists {
+			removed = append(removed, i)
+			continue
+		}
+		delete(curr, oneof.GetName())
+		matched[oneof.GetName()] = oneof.GetName()
 	}
 
+	for _, i := range removed {
+		oneof := previous.OneofDecl[i]
+		fields := oneofFields(previous, int32(i))
+		renamed := false
+		for j, next := range current.OneofDecl {
+			if _, unmatched := curr[next.GetName()]; !unmatched || !cmp.Equal(fields, oneofFields(current, int32(j))) {
+				continue
+			}
+			delete(curr, next.GetName())
+			matched[oneof.GetName()] = next.GetName()
+			report.Add(ProblemRenamedOneof{
+				Position: currMsg.pos(msgOneofDeclPath, int32(j)),
+				Message:  currMsg.name,
+				OldName:  oneof.GetName(),
+				NewName:  next.GetName(),
+			})
+			renamed = true
+			break
+		}
+		if !renamed {
+			report.Add(ProblemRemovedOneof{
+				Position: prevMsg.pos(msgOneofDeclPath, int32(i)),
+				Message:  prevMsg.name,
+				Oneof:    oneof.GetName(),
+			})
+		}
+	}
+
+	for j, oneof := range current.OneofDecl {
+		if _, added := curr[oneof.GetName()]; added {
+			report.Add(AddedOneof{
+				Position: currMsg.pos(msgOneofDeclPath, int32(j)),
+				Message:  currMsg.name,
+				Oneof:    oneof.GetName(),
+			})
+		}
+	}
+	return matched
+}
+
+// oneofName returns the name of the oneof containing field, or "". proto3
+// optional fields are in a synthetic oneof, which doesn't count.
+func oneofName(msg *descriptor.DescriptorProto, field *descriptor.FieldDescriptorProto) string {
+	if field.OneofIndex == nil || proto3Optional(field) {
+		return ""
+	}
+	return msg.OneofDecl[*field.OneofIndex].GetName()
+}
+
+// syntheticOneof reports whether the oneof at index was generated for a
+// proto3 optional field rather than declared.
+func syntheticOneof(msg *descriptor.DescriptorProto, index int32) bool {
+	for _, field := range msg.Field {
+		if field.OneofIndex != nil && *field.OneofIndex == index && proto3Optional(field) {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldDescriptorProto3Optional holds the FieldDescriptorProto field added
+// in protobuf 3.12. The vendored descriptor package predates it, so it ends
+// up in XXX_unrecognized.
+type fieldDescriptorProto3Optional struct {
+	Proto3Optional   *bool  `protobuf:"varint,17,opt,name=proto3_optional,json=proto3Optional"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *fieldDescriptorProto3Optional) Reset()         { *m = fieldDescriptorProto3Optional{} }
+func (m *fieldDescriptorProto3Optional) String() string { return proto.CompactTextString(m) }
+func (*fieldDescriptorProto3Optional) ProtoMessage()    {}
+
+// proto3Optional reports whether field is a proto3 field declared optional.
+func proto3Optional(field *descriptor.FieldDescriptorProto) bool {
+	var decoded fieldDescriptorProto3Optional
+	if err := proto.Unmarshal(field.XXX_unrecognized, &decoded); err != nil {
+		return false
+	}
+	return decoded.Proto3Optional != nil && *decoded.Proto3Optional
+}
+
+// oneofFields returns the numbers of the fields in the oneof at index.
+func oneofFields(msg *descriptor.DescriptorProto, index int32) []int32 {
+	numbers := []int32{}
+	for _, field := range msg.Field {
+		if field.OneofIndex != nil && *field.OneofIndex == index {
+			numbers = append(numbers, field.GetNumber())
+		}
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers
+}
+
+func diffEnum(report *Report, prevEnum, currEnum element, previous, current *descriptor.EnumDescriptorProto) {
+	reserved := enumReservations(current)
+	byname := map[string]int{}
+	values := []declared{}
+
+	for i, value := range current.Value {
+		byname[*value.Name] = i
+		values = append(values, declared{*value.Number, *value.Name, i})
+	}
+	diffReservations(report, prevEnum, currEnum, enumReservations(previous), reserved, values, enumValuePath)
+	diffOptions(report, prevEnum, currEnum, currEnum.name, previous.Options, current.Options)
+	if previous.GetOptions().GetAllowAlias() != current.GetOptions().GetAllowAlias() {
+		report.Add(ProblemChangedEnumAlias{
+			Position:   currEnum.pos(),
+			Enum:       currEnum.name,
+			AllowAlias: current.GetOptions().GetAllowAlias(),
+		})
+	}
+
+	prevNames := map[string]bool{}
 	for _, value := range previous.Value {
-		_, exists := byvalue[*value.Number]
-		if !exists {
-			next, renamed := byname[*value.Name]
-			if renamed {
+		prevNames[*value.Name] = true
+	}
+	// Values are matched by name, and then values whose name is gone by
+	// number, which makes them renames.
+	matched := map[int]bool{}
+	for i, value := range previous.Value {
+		j, exists := byname[*value.Name]
+		if exists {
+			matched[j] = true
+			next := current.Value[j]
+			if *next.Number != *value.Number {
 				report.Add(ProblemChangeEnumValue{
-					Enum:     *previous.Name,
+					Position: currEnum.pos(enumValuePath, int32(j)),
+					Enum:     currEnum.name,
 					Name:     *value.Name,
 					OldValue: *value.Number,
 					NewValue: *next.Number,
 				})
-			} else {
-				report.Add(ProblemRemovedEnumValue{*previous.Name, *value.Name})
 			}
+			currValue := currEnum.child(*next.Name, enumValuePath, int32(j))
+			diffOptions(report, prevEnum, currValue, currValue.name, value.Options, next.Options)
+			continue
+		}
+		j = renamedEnumValue(current, prevNames, matched, *value.Number)
+		if j < 0 {
+			report.Add(ProblemRemovedEnumValue{
+				Position:       prevEnum.pos(enumValuePath, int32(i)),
+				Enum:           prevEnum.name,
+				Name:           *value.Name,
+				NumberReserved: reserved.hasNumber(*value.Number),
+				NameReserved:   reserved.hasName(*value.Name),
+			})
+			continue
+		}
+		matched[j] = true
+		next := current.Value[j]
+		report.Add(ProblemRenamedEnumValue{
+			Position: currEnum.pos(enumValuePath, int32(j)),
+			Enum:     currEnum.name,
+			Number:   *value.Number,
+			OldName:  *value.Name,
+			NewName:  *next.Name,
+		})
+		currValue := currEnum.child(*next.Name, enumValuePath, int32(j))
+		diffOptions(report, prevEnum, currValue, currValue.name, value.Options, next.Options)
+	}
+
+	// The first value is the default, unless it was only renamed.
+	if len(previous.Value) > 0 && len(current.Value) > 0 {
+		first, next := previous.Value[0], current.Value[0]
+		_, kept := byname[first.GetName()]
+		renamed := first.GetNumber() == next.GetNumber() && !kept && !prevNames[next.GetName()]
+		if first.GetNumber() != next.GetNumber() || (first.GetName() != next.GetName() && !renamed) {
+			report.Add(ProblemChangedEnumDefault{
+				Position:   currEnum.pos(enumValuePath, 0),
+				Enum:       currEnum.name,
+				OldDefault: first.GetName(),
+				NewDefault: next.GetName(),
+				OldNumber:  first.GetNumber(),
+				NewNumber:  next.GetNumber(),
+			})
+		}
+	}
+
+	for j, value := range current.Value {
+		if !matched[j] {
+			report.Add(AddedEnumValue{
+				Position: currEnum.pos(enumValuePath, int32(j)),
+				Enum:     currEnum.name,
+				Name:     *value.Name,
+			})
 		}
 	}
 }
 
+// renamedEnumValue returns the index of the value in current that replaces a
+// value with number whose name is gone, or -1. Values whose names were
+// declared in previous, or that are already matched, are skipped.
+func renamedEnumValue(current *descriptor.EnumDescriptorProto, prevNames map[string]bool, matched map[int]bool, number int32) int {
+	for j, value := range current.Value {
+		if value.GetNumber() == number && !prevNames[value.GetName()] && !matched[j] {
+			return j
+		}
+	}
+	return -1
+}
+
 // Golang go-cmp
-func diffService(report *Report, previous, current *descriptor.ServiceDescriptorProto) {
-	curr := map[string]*descriptor.MethodDescriptorProto{}
+func diffService(report *Report, prevSrv, currSrv element, previous, current *descriptor.ServiceDescriptorProto) {
+	diffOptions(report, prevSrv, currSrv, currSrv.name, previous.Options, current.Options)
+	curr := map[string]int{}
 
-	for _, value := range current.GetMethod() {
-		curr[*value.Name] = value
+	for i, value := range current.GetMethod() {
+		curr[*value.Name] = i
 	}
 
-	for _, prev := range previous.GetMethod() {
-		next, exists := curr[*prev.Name]
+	for i, prev := range previous.GetMethod() {
+		j, exists := curr[*prev.Name]
 		if !exists {
-			report.Add(ProblemRemovedServiceMethod{*previous.Name, *prev.Name})
+			report.Add(ProblemRemovedServiceMethod{
+				Position: prevSrv.pos(serviceMethodPath, int32(i)),
+				Service:  prevSrv.name,
+				Name:     *prev.Name,
+			})
 			continue
 		}
+		delete(curr, *prev.Name)
+		next := current.Method[j]
+		currMethod := currSrv.child(*next.Name, serviceMethodPath, int32(j))
+		diffOptions(report, prevSrv, currMethod, currMethod.name, prev.Options, next.Options)
 		if !cmp.Equal(next.InputType, prev.InputType) {
 			report.Add(ProblemChangedService{
-				Service: *current.Name,
-				Side:    "input",
-				Name:    *prev.Name,
-				OldType: *prev.InputType,
-				NewType: *next.InputType,
+				Position:     currMethod.pos(),
+				Service:      currSrv.name,
+				Side:         "input",
+				Name:         *prev.Name,
+				OldType:      *prev.InputType,
+				NewType:      *next.InputType,
+				TypeBreakage: TypeBreakage{Breakage: compareTypes(prevSrv.set, currSrv.set, *prev.InputType, *next.InputType)},
 			})
 		}
 		if !cmp.Equal(next.OutputType, prev.OutputType) {
 			report.Add(ProblemChangedService{
-				Service: *current.Name,
-				Side:    "output",
-				Name:    *prev.Name,
-				OldType: *prev.OutputType,
-				NewType: *next.OutputType,
+				Position:     currMethod.pos(),
+				Service:      currSrv.name,
+				Side:         "output",
+				Name:         *prev.Name,
+				OldType:      *prev.OutputType,
+				NewType:      *next.OutputType,
+				TypeBreakage: TypeBreakage{Breakage: compareTypes(prevSrv.set, currSrv.set, *prev.OutputType, *next.OutputType)},
 			})
 		}
 		if !cmp.Equal(prev.ClientStreaming, next.ClientStreaming) {
 			report.Add(ProblemChangedServiceStreaming{
-				Service:   *current.Name,
+				Position:  currMethod.pos(),
+				Service:   currSrv.name,
 				Name:      *prev.Name,
 				Side:      "client",
 				OldStream: prev.ClientStreaming,
@@ -251,13 +1033,23 @@ func diffService(report *Report, previous, current *descriptor.ServiceDescriptor
 		}
 		if !cmp.Equal(prev.ServerStreaming, next.ServerStreaming) {
 			report.Add(ProblemChangedServiceStreaming{
-				Service:   *current.Name,
+				Position:  currMethod.pos(),
+				Service:   currSrv.name,
 				Name:      *prev.Name,
 				Side:      "server",
 				OldStream: prev.ServerStreaming,
 				NewStream: next.ServerStreaming,
 			})
 		}
+	}
 
+	for j, method := range current.GetMethod() {
+		if _, added := curr[*method.Name]; added {
+			report.Add(AddedServiceMethod{
+				Position: currSrv.pos(serviceMethodPath, int32(j)),
+				Service:  currSrv.name,
+				Name:     *method.Name,
+			})
+		}
 	}
 }
@@ -23,8 +23,9 @@ func generateFileSet(t *testing.T, prefix, name string) descriptor.FileDescripto
 	if err := os.MkdirAll(fdsDir, 0755); err != nil {
 		t.Fatal(err)
 	}
-	// Run protoc
-	cmd := exec.Command("protoc", "-o", fdsFile, protoFile)
+	// Run protoc. Files are named relative to protoDir so that the same file
+	// has the same name in both the previous and current sets.
+	cmd := exec.Command("protoc", "--include_source_info", "--proto_path="+protoDir, "-o", fdsFile, protoFile)
 	if out, err := cmd.CombinedOutput(); err != nil {
 		t.Fatalf("protoc failed: %s %s", err, out)
 	}
@@ -40,27 +41,48 @@ func generateFileSet(t *testing.T, prefix, name string) descriptor.FileDescripto
 
 func TestDiffing(t *testing.T) {
 	files := map[string]string{
-		"changed_client_streaming": "changed client streaming for method 'Invoke' on service 'Foo': false -> true",
-		"changed_server_streaming": "changed server streaming for method 'Invoke' on service 'Foo': true -> false",
-		"changed_enum_value":       "changed value 'bat' on enum 'FOO': 1 -> 2",
-		"changed_field_label":      "changed label for field 'name' on message 'HelloRequest': LABEL_OPTIONAL -> LABEL_REPEATED",
-		"changed_field_name":       "changed name for field #1 on message 'HelloRequest': foo -> bar",
-		"changed_field_type":       "changed types for field 'name' on message 'HelloRequest': TYPE_STRING -> TYPE_BOOL",
-		"changed_package":          "changed package name: foo -> bar",
-		"changed_service_input":    "changed input type for method 'Invoke' on service 'Foo': .helloworld.FooRequest -> .helloworld.BarRequest",
-		"changed_service_output":   "changed output type for method 'Invoke' on service 'Foo': .helloworld.FooResponse -> .helloworld.BarResponse",
-		"removed_enum":             "removed enum 'FOO'",
-		"removed_enum_field":       "removed value 'bat' from enum 'FOO'",
-		"removed_field":            "removed field 'name' from message 'HelloRequest'",
-		"removed_message":          "removed message 'HelloRequest'",
-		"removed_service":          "removed service 'Foo'",
-		"removed_service_method":   "removed method 'Bar' from service 'Foo'",
+		"changed_client_streaming":            "changed client streaming for method 'Invoke' on service '.helloworld.Foo': false -> true",
+		"changed_server_streaming":            "changed server streaming for method 'Invoke' on service '.helloworld.Foo': true -> false",
+		"changed_enum_value":                  "changed value 'bat' on enum '.helloworld.FOO': 1 -> 2",
+		"changed_field_label":                 "changed label for field 'name' on message '.helloworld.HelloRequest': LABEL_OPTIONAL -> LABEL_REPEATED",
+		"renamed_service":                     "renamed service '.helloworld.Foo' to '.helloworld.FooService': gRPC paths change from /helloworld.Foo/Bar, /helloworld.Foo/Baz to /helloworld.FooService/Bar, /helloworld.FooService/Baz",
+		"removed_extension":                   "removed extension '.helloworld.bar' (#100 of '.helloworld.Foo')",
+		"changed_extension":                   "changed extension #100 of '.helloworld.Foo': optional string .helloworld.bar -> optional int64 .helloworld.bar",
+		"removed_extension_range":             "removed extension numbers 150 to 199 from message '.helloworld.Foo'",
+		"changed_field_json_name":             "changed JSON name for field 'name' on message '.helloworld.HelloRequest': userName -> displayName",
+		"changed_field_name":                  "changed name for field #1 on message '.helloworld.HelloRequest': foo -> bar",
+		"changed_field_name_json_compatible":  "changed name for field #1 on message '.helloworld.HelloRequest': user_name -> userName",
+		"changed_field_oneof":                 "moved field 'name' on message '.helloworld.HelloRequest' into oneof 'greeting'",
+		"changed_field_oneof_out":             "moved field 'hello' on message '.helloworld.HelloRequest' out of oneof 'greeting'",
+		"changed_field_option_packed":         "changed option 'packed' on '.helloworld.HelloRequest.ids': false -> true",
+		"changed_field_type":                  "changed types for field 'name' on message '.helloworld.HelloRequest': TYPE_STRING -> TYPE_BOOL",
+		"changed_field_type_bytes":            "changed types for field 'name' on message '.helloworld.HelloRequest': TYPE_STRING -> TYPE_BYTES",
+		"changed_field_type_name":             "changed message or enum type for field 'request' on message '.helloworld.Wrapper': .helloworld.FooRequest -> .helloworld.BarRequest",
+		"changed_package":                     "changed package name: foo -> bar",
+		"changed_service_output_incompatible": "changed output type for method 'Invoke' on service '.helloworld.Foo': .helloworld.FooResponse -> .helloworld.BarResponse",
+		"removed_enum_field_reserved":         "removed value 'bat' from enum '.helloworld.FOO' (number reserved)",
+		"removed_enum_reserved_range":         "removed reservation of numbers 3 to 4 from '.helloworld.FOO'",
+		"removed_enum_field":                  "removed value 'bat' from enum '.helloworld.FOO'",
+		"removed_field":                       "removed field 'name' from message '.helloworld.HelloRequest'",
+		"removed_nested_enum_field":           "removed value 'bat' from enum '.helloworld.Outer.FOO'",
+		"removed_nested_field":                "removed field 'name' from message '.helloworld.Outer.Inner'",
+		"reused_field_number":                 "reused field #1 on message '.helloworld.HelloRequest' for a different field: string name -> int64 id",
+		"removed_service":                     "removed service '.helloworld.Foo'",
+		"removed_service_method":              "removed method 'Bar' from service '.helloworld.Foo'",
+		"added_required_field":                "added required field 'greeting' to message '.helloworld.HelloRequest'",
+		"changed_field_required":              "made field 'name' on message '.helloworld.HelloRequest' required",
+		"changed_field_default":               "changed default for field 'count' on message '.helloworld.HelloRequest': 1 -> 10",
+		"changed_field_default_enum":          "changed default for field 'foo' on message '.helloworld.HelloRequest': bat -> bar",
+		"renamed_enum_zero_value":             "renamed value 0 on enum '.helloworld.Color': COLOR_UNKNOWN -> COLOR_UNSPECIFIED",
+		"renamed_enum_default_value":          "renamed value 1 on enum '.helloworld.FOO': bat -> baz",
+		"renamed_enum_value":                  "renamed value 1 on enum '.helloworld.FOO': bat -> baz",
+		"changed_enum_default_order":          "changed default value of enum '.helloworld.FOO': bar -> bat",
+		"changed_map_key":                     "changed map field 'counts' on message '.helloworld.HelloRequest': map<string, int32> -> map<int32, int32>",
 	}
 	for name, problem := range files {
 		t.Run(name, func(t *testing.T) {
 			prev := generateFileSet(t, "previous", name)
 			curr := generateFileSet(t, "current", name)
-			// Won't work with --include_imports
 			report, err := DiffSet(&prev, &curr)
 			if err == nil {
 				t.Fatal("expected diff to have an error")
@@ -78,3 +100,508 @@ func TestDiffing(t *testing.T) {
 		})
 	}
 }
+
+// Changes that only break generated code are warnings, so they don't make the
+// diff fail.
+func TestSourceChanges(t *testing.T) {
+	files := map[string]string{
+		"changed_file_option":                `changed option 'go_package' on 'changed_file_option.proto': "example.com/foo" -> "example.com/bar"`,
+		"changed_method_option":              "changed option 'idempotency_level' on '.helloworld.Foo.Invoke': IDEMPOTENCY_UNKNOWN -> NO_SIDE_EFFECTS",
+		"changed_field_type_compatible":      "changed types for field 'count' on message '.helloworld.HelloRequest': TYPE_INT32 -> TYPE_INT64",
+		"changed_field_type_name_compatible": "changed message or enum type for field 'request' on message '.helloworld.Wrapper': .helloworld.FooRequest -> .helloworld.BarRequest",
+		"changed_service_input":              "changed input type for method 'Invoke' on service '.helloworld.Foo': .helloworld.FooRequest -> .helloworld.BarRequest",
+		"changed_service_input_recursive":    "changed input type for method 'Invoke' on service '.helloworld.Foo': .helloworld.FooRequest -> .helloworld.BarRequest",
+		"changed_service_output":             "changed output type for method 'Invoke' on service '.helloworld.Foo': .helloworld.FooResponse -> .helloworld.BarResponse",
+		"removed_enum":                       "removed enum '.helloworld.FOO'",
+		"removed_message":                    "removed message '.helloworld.HelloRequest'",
+		"removed_nested_message":             "removed message '.helloworld.Outer.Middle.Inner'",
+		"renamed_oneof":                      "renamed oneof on message '.helloworld.HelloRequest': greeting -> salutation",
+		"changed_syntax":                     "changed syntax: proto2 -> proto3",
+		"changed_map_value":                  "changed map field 'counts' on message '.helloworld.HelloRequest': map<string, int32> -> map<string, int64>",
+	}
+	for name, problem := range files {
+		t.Run(name, func(t *testing.T) {
+			prev := generateFileSet(t, "previous", name)
+			curr := generateFileSet(t, "current", name)
+			report, err := DiffSet(&prev, &curr)
+			if err != nil {
+				t.Fatalf("expected source-only change not to be an error: %s", err)
+			}
+			if len(report.Changes) != 1 {
+				t.Fatalf("expected report to have one problem, has %d: %v", len(report.Changes), report)
+			}
+			if report.Changes[0].String() != problem {
+				t.Errorf("expected problem: %s", problem)
+				t.Errorf("  actual problem: %s", report.Changes[0].String())
+			}
+			if report.Changes[0].Breaks() != BreaksSource {
+				t.Errorf("expected problem to only break source, breaks %s", report.Changes[0].Breaks())
+			}
+		})
+	}
+}
+
+// Given a directory name and several .proto files, generate a single
+// FileDescriptorSet containing all of them.
+func generateMultiFileSet(t *testing.T, prefix string, names ...string) descriptor.FileDescriptorSet {
+	var fds descriptor.FileDescriptorSet
+	for _, name := range names {
+		set := generateFileSet(t, prefix, name)
+		fds.File = append(fds.File, set.File...)
+	}
+	return fds
+}
+
+func TestCompatibleChanges(t *testing.T) {
+	files := map[string]string{
+		"added_enum":             "added enum '.helloworld.FOO'",
+		"added_enum_value":       "added value 'bat' to enum '.helloworld.FOO'",
+		"added_extension":        "added extension '.helloworld.Bar.baz' (#101 of '.helloworld.Foo')",
+		"added_field":            "added field 'greeting' to message '.helloworld.HelloRequest'",
+		"added_message":          "added message '.helloworld.Outer.Inner'",
+		"added_service":          "added service '.helloworld.Foo'",
+		"added_service_method":   "added method 'Bar' to service '.helloworld.Foo'",
+		"changed_field_option":   "changed option 'deprecated' on '.helloworld.HelloRequest.name': false -> true",
+		"removed_field_reserved": "removed field 'name' from message '.helloworld.HelloRequest' (number and name reserved)",
+	}
+	for name, change := range files {
+		t.Run(name, func(t *testing.T) {
+			prev := generateFileSet(t, "previous", name)
+			curr := generateFileSet(t, "current", name)
+			report, err := DiffSet(&prev, &curr)
+			if err != nil {
+				t.Fatalf("expected change not to be an error: %s", err)
+			}
+			if len(report.Changes) != 1 {
+				t.Fatalf("expected report to have one change, has %d: %v", len(report.Changes), report)
+			}
+			if report.Changes[0].String() != change {
+				t.Errorf("expected change: %s", change)
+				t.Errorf("  actual change: %s", report.Changes[0].String())
+			}
+			if len(report.Breaking(BreaksAll)) != 0 {
+				t.Errorf("expected change not to break clients")
+			}
+		})
+	}
+}
+
+func TestProto3Optional(t *testing.T) {
+	prev := generateFileSet(t, "previous", "changed_field_proto3_optional")
+	curr := generateFileSet(t, "current", "changed_field_proto3_optional")
+	report, err := DiffSet(&prev, &curr)
+	if err != nil {
+		t.Fatalf("expected proto3 optional not to break clients: %s", err)
+	}
+	if len(report.Changes) != 0 {
+		t.Errorf("expected synthetic oneofs to be ignored, got %v", report.Changes)
+	}
+}
+
+func TestDiffSetFiles(t *testing.T) {
+	tests := map[string]struct {
+		previous []string
+		current  []string
+		problems []string
+		// breaks, if set, must be broken by at least one problem.
+		breaks Breakage
+		// warning is set if the problems only break generated code, so
+		// the diff doesn't fail.
+		warning bool
+	}{
+		"multiple_files": {
+			previous: []string{"removed_field", "removed_enum"},
+			current:  []string{"removed_field", "removed_enum"},
+			problems: []string{
+				"removed field 'name' from message '.helloworld.HelloRequest'",
+				"removed enum '.helloworld.FOO'",
+			},
+		},
+		"added_file": {
+			previous: []string{"removed_field"},
+			current:  []string{"removed_field", "removed_enum"},
+			problems: []string{
+				"removed field 'name' from message '.helloworld.HelloRequest'",
+				"added file 'removed_enum.proto'",
+			},
+		},
+		"removed_file": {
+			previous: []string{"removed_field", "removed_enum"},
+			current:  []string{"removed_field"},
+			problems: []string{
+				"removed field 'name' from message '.helloworld.HelloRequest'",
+				"removed file 'removed_enum.proto'",
+				"removed enum '.helloworld.FOO'",
+			},
+		},
+		"removed_service_file": {
+			previous: []string{"removed_field", "removed_service_method"},
+			current:  []string{"removed_field"},
+			problems: []string{
+				"removed field 'name' from message '.helloworld.HelloRequest'",
+				"removed file 'removed_service_method.proto'",
+				"removed service '.helloworld.Foo'",
+				"removed message '.helloworld.Empty'",
+			},
+			breaks: BreaksWire,
+		},
+		"removed_oneof": {
+			previous: []string{"removed_oneof"},
+			current:  []string{"removed_oneof"},
+			problems: []string{
+				"removed oneof 'greeting' from message '.helloworld.HelloRequest'",
+				"moved field 'hello' on message '.helloworld.HelloRequest' out of oneof 'greeting'",
+			},
+		},
+		"changed_enum_alias": {
+			previous: []string{"changed_enum_alias"},
+			current:  []string{"changed_enum_alias"},
+			warning:  true,
+			problems: []string{
+				"allowed aliases on enum '.helloworld.FOO'",
+				"added value 'baz' to enum '.helloworld.FOO'",
+			},
+		},
+		"changed_enum_default": {
+			previous: []string{"changed_enum_default"},
+			current:  []string{"changed_enum_default"},
+			problems: []string{
+				"changed default value of enum '.helloworld.FOO': bar -> unknown",
+				"added value 'unknown' to enum '.helloworld.FOO'",
+			},
+		},
+		"unnested_message": {
+			previous: []string{"unnested_message"},
+			current:  []string{"unnested_message"},
+			problems: []string{
+				"changed message or enum type for field 'inner' on message '.helloworld.Outer': .helloworld.Outer.Inner -> .helloworld.Inner",
+				"moved message '.helloworld.Outer.Inner' to '.helloworld.Inner'",
+			},
+		},
+		"unnested_removed_parent": {
+			previous: []string{"unnested_removed_parent"},
+			current:  []string{"unnested_removed_parent"},
+			problems: []string{
+				"removed message '.helloworld.Outer'",
+				"moved message '.helloworld.Outer.Inner' to '.helloworld.Inner'",
+			},
+		},
+		"unnested_removed_file": {
+			previous: []string{"unnested_removed_parent"},
+			current:  []string{"unnested_removed_parent_v2"},
+			problems: []string{
+				"removed file 'unnested_removed_parent.proto'",
+				"removed message '.helloworld.Outer'",
+				"added file 'unnested_removed_parent_v2.proto'",
+				"moved message '.helloworld.Outer.Inner' to '.helloworld.Inner'",
+			},
+		},
+		"moved_package": {
+			previous: []string{"moved_package"},
+			current:  []string{"moved_package_v2"},
+			problems: []string{
+				"removed file 'moved_package.proto'",
+				"moved enum '.helloworld.Greeting' to '.greeter.v1.Greeting'",
+				"moved message '.helloworld.HelloRequest' to '.greeter.v1.HelloRequest'",
+				"changed message or enum type for field 'greeting' on message '.greeter.v1.HelloRequest': .helloworld.Greeting -> .greeter.v1.Greeting",
+				"added file 'moved_package_v2.proto'",
+			},
+		},
+		"changed_map_repeated": {
+			previous: []string{"changed_map_repeated"},
+			current:  []string{"changed_map_repeated"},
+			problems: []string{
+				"changed map field 'counts' on message '.helloworld.HelloRequest': map<string, int32> -> repeated .helloworld.HelloRequest.Count",
+				"added message '.helloworld.HelloRequest.Count'",
+			},
+		},
+		"renamed_service_methods": {
+			previous: []string{"renamed_service_methods"},
+			current:  []string{"renamed_service_methods"},
+			problems: []string{
+				"renamed service '.helloworld.Foo' to '.helloworld.FooService': gRPC paths change from /helloworld.Foo/Bar to /helloworld.FooService/Bar",
+				"removed method 'Baz' from service '.helloworld.Foo'",
+				"added method 'Qux' to service '.helloworld.FooService'",
+				"removed service '.helloworld.Greeter'",
+				"added service '.helloworld.Welcomer'",
+			},
+		},
+		"reused_reserved": {
+			previous: []string{"reused_reserved"},
+			current:  []string{"reused_reserved"},
+			problems: []string{
+				"removed reservation of number 2 from '.helloworld.HelloRequest'",
+				"removed reservation of name 'greeting' from '.helloworld.HelloRequest'",
+				"reused reserved number 2 for 'greeting' on '.helloworld.HelloRequest'",
+				"reused reserved name 'greeting' on '.helloworld.HelloRequest'",
+				"added field 'greeting' to message '.helloworld.HelloRequest'",
+			},
+		},
+		"reused_field_number_moved": {
+			previous: []string{"reused_field_number_moved"},
+			current:  []string{"reused_field_number_moved"},
+			problems: []string{
+				"reused field #1 on message '.helloworld.HelloRequest' for a different field: string name -> string nickname",
+				"added field 'name' to message '.helloworld.HelloRequest'",
+			},
+		},
+		"renamed_file": {
+			previous: []string{"renamed_file"},
+			current:  []string{"renamed_file_v2"},
+			problems: []string{
+				"renamed file 'renamed_file.proto' -> 'renamed_file_v2.proto'",
+				"removed field 'name' from message '.helloworld.HelloRequest'",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			prev := generateMultiFileSet(t, "previous", tt.previous...)
+			curr := generateMultiFileSet(t, "current", tt.current...)
+			report, err := DiffSet(&prev, &curr)
+			if err == nil && !tt.warning {
+				t.Fatal("expected diff to have an error")
+			}
+			if err != nil && tt.warning {
+				t.Fatalf("expected diff not to have an error: %s", err)
+			}
+			if len(report.Changes) != len(tt.problems) {
+				t.Fatalf("expected report to have %d problems, has %d: %v", len(tt.problems), len(report.Changes), report.Changes)
+			}
+			for i, problem := range tt.problems {
+				if report.Changes[i].String() != problem {
+					t.Errorf("expected problem: %s", problem)
+					t.Errorf("  actual problem: %s", report.Changes[i].String())
+				}
+			}
+			if tt.breaks != 0 && len(report.Breaking(tt.breaks)) == 0 {
+				t.Errorf("expected a problem to break %s", tt.breaks)
+			}
+		})
+	}
+}
+
+func TestProblemNames(t *testing.T) {
+	prev := generateMultiFileSet(t, "previous", "renamed_file")
+	curr := generateMultiFileSet(t, "current", "renamed_file_v2")
+	report, _ := DiffSet(&prev, &curr)
+	expected := ProblemRemovedField{
+		Position: Position{File: "renamed_file.proto", Line: 6, Column: 3},
+		Message:  ".helloworld.HelloRequest",
+		Field:    "name",
+	}
+	if len(report.Changes) != 2 || report.Changes[1] != expected {
+		t.Errorf("expected %#v, got %#v", expected, report.Changes)
+	}
+}
+
+// TestChanges checks the details of the first change in each fixture. Empty
+// expectations aren't checked.
+func TestChanges(t *testing.T) {
+	files := map[string]struct {
+		kind   Kind
+		path   string
+		breaks Breakage
+		pos    string
+		values []string
+	}{
+		"added_required_field":               {breaks: BreaksWire | BreaksJSON},
+		"added_service_method":               {kind: KindMethodAdded, path: ".helloworld.Foo.Bar"},
+		"changed_enum_default":               {breaks: BreaksJSON | BreaksSource},
+		"changed_enum_default_order":         {breaks: BreaksAll},
+		"changed_enum_value":                 {kind: KindEnumValueChanged, path: ".helloworld.FOO.bat", breaks: BreaksWire, values: []string{"1", "2"}},
+		"changed_field_default":              {breaks: BreaksWire | BreaksJSON, values: []string{"1", "10"}},
+		"changed_field_json_name":            {breaks: BreaksJSON},
+		"changed_field_name":                 {kind: KindFieldNameChanged, path: ".helloworld.HelloRequest.bar", breaks: BreaksJSON | BreaksSource},
+		"changed_field_required":             {breaks: BreaksWire | BreaksJSON},
+		"changed_field_type":                 {breaks: BreaksAll, pos: "changed_field_type.proto:6:3", values: []string{"TYPE_STRING", "TYPE_BOOL"}},
+		"changed_field_type_bytes":           {breaks: BreaksJSON | BreaksSource},
+		"changed_field_type_compatible":      {breaks: BreaksSource},
+		"changed_field_type_name":            {breaks: BreaksAll},
+		"changed_field_type_name_compatible": {breaks: BreaksSource},
+		"changed_file_option":                {values: []string{`"example.com/foo"`, `"example.com/bar"`}},
+		"changed_map_key":                    {breaks: BreaksAll, values: []string{"map<string, int32>", "map<int32, int32>"}},
+		"changed_map_repeated":               {breaks: BreaksJSON | BreaksSource},
+		"changed_map_value":                  {breaks: BreaksSource},
+		"changed_package":                    {pos: "changed_package.proto:2:1"},
+		"changed_service_input":              {pos: "changed_service_input.proto:10:3"},
+		"removed_field":                      {kind: KindFieldRemoved, path: ".helloworld.HelloRequest.name"},
+		"removed_message":                    {kind: KindMessageRemoved, path: ".helloworld.HelloRequest", breaks: BreaksSource, pos: "removed_message.proto:5:1"},
+		"removed_nested_enum_field":          {pos: "removed_nested_enum_field.proto:8:5"},
+		"renamed_enum_value":                 {breaks: BreaksJSON | BreaksSource},
+		"renamed_enum_zero_value":            {breaks: BreaksJSON | BreaksSource},
+	}
+	for name, tt := range files {
+		t.Run(name, func(t *testing.T) {
+			prev := generateFileSet(t, "previous", name)
+			curr := generateFileSet(t, "current", name)
+			report, _ := DiffSet(&prev, &curr)
+			if len(report.Changes) == 0 {
+				t.Fatal("expected report to have at least one change")
+			}
+			c := report.Changes[0]
+			if tt.kind != "" && c.Kind() != tt.kind {
+				t.Errorf("expected kind %s, got %s", tt.kind, c.Kind())
+			}
+			if tt.path != "" && c.Path() != tt.path {
+				t.Errorf("expected path %s, got %s", tt.path, c.Path())
+			}
+			if tt.breaks != 0 {
+				if c.Breaks() != tt.breaks {
+					t.Errorf("expected breakage %s, got %s", tt.breaks, c.Breaks())
+				}
+				if len(report.Breaking(^tt.breaks)) != 0 {
+					t.Errorf("expected no changes outside of %s", tt.breaks)
+				}
+			}
+			if tt.pos != "" && c.Pos().String() != tt.pos {
+				t.Errorf("expected position %s, got %s", tt.pos, c.Pos())
+			}
+			if tt.values != nil {
+				v, ok := c.(ValueChange)
+				if !ok {
+					t.Fatalf("expected %T to have values", c)
+				}
+				if prev, curr := v.Values(); prev != tt.values[0] || curr != tt.values[1] {
+					t.Errorf("expected values %q -> %q, got %q -> %q", tt.values[0], tt.values[1], prev, curr)
+				}
+			}
+		})
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	tests := map[Breakage]Severity{
+		BreaksAll:                 SeverityError,
+		BreaksWire:                SeverityError,
+		BreaksJSON | BreaksSource: SeverityError,
+		BreaksSource:              SeverityWarning,
+		0:                         SeverityInfo,
+	}
+	for b, expected := range tests {
+		if b.Severity() != expected {
+			t.Errorf("expected %s to be %s, got %s", b, expected, b.Severity())
+		}
+	}
+}
+
+func TestParseBreakage(t *testing.T) {
+	tests := map[string]Breakage{
+		"wire":        BreaksWire,
+		"json,source": BreaksJSON | BreaksSource,
+		"all":         BreaksAll,
+		"none":        0,
+	}
+	for s, expected := range tests {
+		b, err := ParseBreakage(s)
+		if err != nil {
+			t.Errorf("ParseBreakage(%q): %s", s, err)
+		}
+		if b != expected {
+			t.Errorf("ParseBreakage(%q) = %s, expected %s", s, b, expected)
+		}
+	}
+	if _, err := ParseBreakage("binary"); err == nil {
+		t.Error("expected an error for an unknown breakage")
+	}
+}
+
+func TestWireCompatible(t *testing.T) {
+	tests := []struct {
+		a, b       descriptor.FieldDescriptorProto_Type
+		compatible bool
+	}{
+		{descriptor.FieldDescriptorProto_TYPE_INT32, descriptor.FieldDescriptorProto_TYPE_UINT64, true},
+		{descriptor.FieldDescriptorProto_TYPE_BOOL, descriptor.FieldDescriptorProto_TYPE_ENUM, true},
+		{descriptor.FieldDescriptorProto_TYPE_SINT32, descriptor.FieldDescriptorProto_TYPE_SINT64, true},
+		{descriptor.FieldDescriptorProto_TYPE_FIXED64, descriptor.FieldDescriptorProto_TYPE_SFIXED64, true},
+		{descriptor.FieldDescriptorProto_TYPE_BYTES, descriptor.FieldDescriptorProto_TYPE_MESSAGE, true},
+		{descriptor.FieldDescriptorProto_TYPE_INT32, descriptor.FieldDescriptorProto_TYPE_SINT32, false},
+		{descriptor.FieldDescriptorProto_TYPE_FIXED32, descriptor.FieldDescriptorProto_TYPE_FIXED64, false},
+		{descriptor.FieldDescriptorProto_TYPE_STRING, descriptor.FieldDescriptorProto_TYPE_MESSAGE, false},
+	}
+	for _, tt := range tests {
+		if wireCompatible(tt.a, tt.b) != tt.compatible {
+			t.Errorf("wireCompatible(%s, %s) != %t", tt.a, tt.b, tt.compatible)
+		}
+		if wireCompatible(tt.b, tt.a) != tt.compatible {
+			t.Errorf("wireCompatible(%s, %s) != %t", tt.b, tt.a, tt.compatible)
+		}
+	}
+}
+
+func TestOptionPolicy(t *testing.T) {
+	prev := generateFileSet(t, "previous", "changed_field_option")
+	curr := generateFileSet(t, "current", "changed_field_option")
+	report, _ := DiffSet(&prev, &curr)
+	if len(report.Breaking(BreaksAll)) != 0 {
+		t.Fatalf("expected deprecating a field not to break clients")
+	}
+	policy := DefaultOptionPolicy()
+	policy["deprecated"] = BreaksSource
+	custom, _ := (&Options{OptionPolicy: policy}).DiffSet(&prev, &curr)
+	if len(custom.Breaking(BreaksSource)) != 1 {
+		t.Errorf("expected deprecating a field to break source with a custom policy")
+	}
+	policy["deprecated"] = 0
+	if len(custom.Breaking(BreaksSource)) != 1 {
+		t.Errorf("expected changing the policy after the diff not to change the report")
+	}
+	if len(report.Breaking(BreaksAll)) != 0 {
+		t.Errorf("expected a custom policy not to change the default policy")
+	}
+}
+
+func TestCustomOptionPolicy(t *testing.T) {
+	prev := generateFileSet(t, "previous", "changed_custom_option")
+	curr := generateFileSet(t, "current", "changed_custom_option")
+	report, err := DiffSet(&prev, &curr)
+	if err != nil {
+		t.Fatalf("expected custom options not to break clients by default: %s", err)
+	}
+	changes := []string{
+		"changed option '(helloworld.http).get' on '.helloworld.Greeter.SayHello': \"/v1/hello\" -> unset",
+		"changed option '(helloworld.http).post' on '.helloworld.Greeter.SayHello': unset -> \"/v1/hello\"",
+		"changed option '(helloworld.scope)' on '.helloworld.Greeter.SayHello': \"read\" -> \"write\"",
+		"changed option '(helloworld.visibility)' on '.helloworld.HelloRequest.name': [PUBLIC] -> [PUBLIC, INTERNAL]",
+	}
+	if len(report.Changes) != len(changes) {
+		t.Fatalf("expected report to have %d changes, has %d: %v", len(changes), len(report.Changes), report.Changes)
+	}
+	for i, change := range changes {
+		if report.Changes[i].String() != change {
+			t.Errorf("expected change: %s", change)
+			t.Errorf("  actual change: %s", report.Changes[i].String())
+		}
+	}
+	policy := DefaultOptionPolicy()
+	policy["(helloworld.scope)"] = BreaksWire
+	policy["(helloworld.http)"] = BreaksJSON
+	report, _ = (&Options{OptionPolicy: policy}).DiffSet(&prev, &curr)
+	if n := len(report.Breaking(BreaksWire)); n != 1 {
+		t.Errorf("expected one wire breaking change, got %d", n)
+	}
+	if n := len(report.Breaking(BreaksJSON)); n != 2 {
+		t.Errorf("expected (http) policy to apply to its fields, got %d JSON breaking changes", n)
+	}
+}
+
+func TestJSONName(t *testing.T) {
+	tests := map[string]string{
+		"name":           "name",
+		"user_name":      "userName",
+		"user__name":     "userName",
+		"http2_endpoint": "http2Endpoint",
+		"_private":       "Private",
+	}
+	for name, expected := range tests {
+		field := &descriptor.FieldDescriptorProto{Name: proto.String(name)}
+		if actual := jsonName(field); actual != expected {
+			t.Errorf("jsonName(%q) = %q, expected %q", name, actual, expected)
+		}
+	}
+	field := &descriptor.FieldDescriptorProto{Name: proto.String("name"), JsonName: proto.String("userName")}
+	if actual := jsonName(field); actual != "userName" {
+		t.Errorf("expected json_name to take precedence, got %q", actual)
+	}
+}
@@ -0,0 +1,123 @@
+package diff
+
+import (
+	"strings"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// extensionKey identifies an extension field across versions. Extensions
+// can be declared anywhere, so they're matched by the message they extend
+// and their number rather than by name.
+type extensionKey struct {
+	extendee string
+	number   int32
+}
+
+// diffExtensions compares the extension fields declared in a file or message.
+// prevScope and currScope identify the file or message on each side, and
+// path is the SourceCodeInfo field number of the extensions within it.
+func diffExtensions(report *Report, prevScope, currScope element, previous, current []*descriptor.FieldDescriptorProto, path int32) {
+	curr := map[extensionKey]int{}
+	for j, ext := range current {
+		curr[extensionKey{ext.GetExtendee(), ext.GetNumber()}] = j
+	}
+
+	for i, ext := range previous {
+		key := extensionKey{ext.GetExtendee(), ext.GetNumber()}
+		j, exists := curr[key]
+		if !exists {
+			report.Add(ProblemRemovedExtension{
+				Position: prevScope.pos(path, int32(i)),
+				Extendee: ext.GetExtendee(),
+				Number:   ext.GetNumber(),
+				Name:     prevScope.name + "." + ext.GetName(),
+			})
+			continue
+		}
+		delete(curr, key)
+		next := current[j]
+		currExt := currScope.child(next.GetName(), path, int32(j))
+		oldType, newType := extensionType(ext), extensionType(next)
+		if oldType != newType || ext.GetName() != next.GetName() {
+			report.Add(ProblemChangedExtension{
+				Position:     currExt.pos(),
+				Extendee:     next.GetExtendee(),
+				Number:       next.GetNumber(),
+				OldName:      prevScope.name + "." + ext.GetName(),
+				NewName:      currScope.name + "." + next.GetName(),
+				OldType:      oldType,
+				NewType:      newType,
+				TypeBreakage: TypeBreakage{Breakage: extensionBreakage(prevScope.set, currScope.set, ext, next)},
+			})
+		}
+		diffOptions(report, prevScope, currExt, currExt.name, fieldOptions(prevScope.file, ext), fieldOptions(currScope.file, next))
+	}
+
+	for j, ext := range current {
+		if _, added := curr[extensionKey{ext.GetExtendee(), ext.GetNumber()}]; added {
+			report.Add(AddedExtension{
+				Position: currScope.pos(path, int32(j)),
+				Extendee: ext.GetExtendee(),
+				Number:   ext.GetNumber(),
+				Name:     currScope.name + "." + ext.GetName(),
+			})
+		}
+	}
+}
+
+// extensionType formats the label and type of an extension, e.g.
+// "optional string" or "repeated .helloworld.Foo".
+func extensionType(ext *descriptor.FieldDescriptorProto) string {
+	label := strings.ToLower(strings.TrimPrefix(ext.GetLabel().String(), "LABEL_"))
+	return label + " " + fieldTypeName(ext)
+}
+
+// extensionBreakage returns how changing the extension prev to next breaks
+// clients. In JSON and the text format, extensions are named by their fully
+// qualified name.
+func extensionBreakage(prevSet, currSet *fileSet, prev, next *descriptor.FieldDescriptorProto) Breakage {
+	var b Breakage
+	if prev.GetName() != next.GetName() {
+		b |= BreaksJSON
+	}
+	if prev.GetLabel() != next.GetLabel() {
+		b |= BreaksWire | BreaksJSON
+	}
+	if !wireCompatible(prev.GetType(), next.GetType()) {
+		b |= BreaksWire
+	}
+	if !jsonCompatible(prev.GetType(), next.GetType()) {
+		b |= BreaksJSON
+	}
+	if prev.GetType() == next.GetType() && prev.GetTypeName() != next.GetTypeName() {
+		b |= compareTypes(prevSet, currSet, prev.GetTypeName(), next.GetTypeName()) &^ BreaksSource
+	}
+	return b
+}
+
+// extensionRanges returns the extension ranges of msg as inclusive ranges.
+func extensionRanges(msg *descriptor.DescriptorProto) []numberRange {
+	ranges := []numberRange{}
+	for i, er := range msg.ExtensionRange {
+		// Like reserved ranges, the end of an extension range is exclusive.
+		ranges = append(ranges, numberRange{er.GetStart(), er.GetEnd() - 1, i})
+	}
+	return ranges
+}
+
+// diffExtensionRanges reports numbers that extensions of a message could use
+// in previous but not in current.
+func diffExtensionRanges(report *Report, prevMsg, currMsg element, previous, current *descriptor.DescriptorProto) {
+	curr := extensionRanges(current)
+	for _, er := range extensionRanges(previous) {
+		for _, dropped := range er.minus(curr) {
+			report.Add(ProblemRemovedExtensionRange{
+				Position: prevMsg.pos(msgExtensionRangePath, int32(dropped.index)),
+				Message:  prevMsg.name,
+				Start:    dropped.start,
+				End:      dropped.end,
+			})
+		}
+	}
+}
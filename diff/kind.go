@@ -0,0 +1,106 @@
+package diff
+
+// Kind identifies the type of a Change. Kinds are stable across releases, so
+// tools can match on them instead of on the text of a change.
+type Kind string
+
+const (
+	// Files and packages
+	KindFileAdded      Kind = "FILE_ADDED"
+	KindFileRemoved    Kind = "FILE_REMOVED"
+	KindFileRenamed    Kind = "FILE_RENAMED"
+	KindPackageChanged Kind = "PACKAGE_CHANGED"
+	KindSyntaxChanged  Kind = "SYNTAX_CHANGED"
+	KindOptionChanged  Kind = "OPTION_CHANGED"
+
+	// Messages and enums
+	KindMessageAdded   Kind = "MESSAGE_ADDED"
+	KindMessageRemoved Kind = "MESSAGE_REMOVED"
+	KindEnumAdded      Kind = "ENUM_ADDED"
+	KindEnumRemoved    Kind = "ENUM_REMOVED"
+	KindTypeMoved      Kind = "TYPE_MOVED"
+
+	// Fields
+	KindFieldAdded           Kind = "FIELD_ADDED"
+	KindRequiredFieldAdded   Kind = "REQUIRED_FIELD_ADDED"
+	KindFieldRemoved         Kind = "FIELD_REMOVED"
+	KindFieldNameChanged     Kind = "FIELD_NAME_CHANGED"
+	KindFieldJSONNameChanged Kind = "FIELD_JSON_NAME_CHANGED"
+	KindFieldTypeChanged     Kind = "FIELD_TYPE_CHANGED"
+	KindFieldTypeNameChanged Kind = "FIELD_TYPE_NAME_CHANGED"
+	KindMapFieldChanged      Kind = "MAP_FIELD_CHANGED"
+	KindFieldLabelChanged    Kind = "FIELD_LABEL_CHANGED"
+	KindFieldRequiredChanged Kind = "FIELD_REQUIRED_CHANGED"
+	KindFieldDefaultChanged  Kind = "FIELD_DEFAULT_CHANGED"
+	KindFieldNumberReused    Kind = "FIELD_NUMBER_REUSED"
+	KindFieldOneofChanged    Kind = "FIELD_ONEOF_CHANGED"
+
+	// Extensions
+	KindExtensionAdded        Kind = "EXTENSION_ADDED"
+	KindExtensionRemoved      Kind = "EXTENSION_REMOVED"
+	KindExtensionChanged      Kind = "EXTENSION_CHANGED"
+	KindExtensionRangeRemoved Kind = "EXTENSION_RANGE_REMOVED"
+
+	// Oneofs
+	KindOneofAdded   Kind = "ONEOF_ADDED"
+	KindOneofRemoved Kind = "ONEOF_REMOVED"
+	KindOneofRenamed Kind = "ONEOF_RENAMED"
+
+	// Enum values
+	KindEnumValueAdded     Kind = "ENUM_VALUE_ADDED"
+	KindEnumValueRemoved   Kind = "ENUM_VALUE_REMOVED"
+	KindEnumValueChanged   Kind = "ENUM_VALUE_CHANGED"
+	KindEnumValueRenamed   Kind = "ENUM_VALUE_RENAMED"
+	KindEnumDefaultChanged Kind = "ENUM_DEFAULT_CHANGED"
+	KindEnumAliasChanged   Kind = "ENUM_ALIAS_CHANGED"
+
+	// Reservations
+	KindReservedRangeRemoved Kind = "RESERVED_RANGE_REMOVED"
+	KindReservedNameRemoved  Kind = "RESERVED_NAME_REMOVED"
+	KindReservedNumberReused Kind = "RESERVED_NUMBER_REUSED"
+	KindReservedNameReused   Kind = "RESERVED_NAME_REUSED"
+
+	// Services
+	KindServiceAdded           Kind = "SERVICE_ADDED"
+	KindServiceRemoved         Kind = "SERVICE_REMOVED"
+	KindServiceRenamed         Kind = "SERVICE_RENAMED"
+	KindMethodAdded            Kind = "METHOD_ADDED"
+	KindMethodRemoved          Kind = "METHOD_REMOVED"
+	KindMethodTypeChanged      Kind = "METHOD_TYPE_CHANGED"
+	KindMethodStreamingChanged Kind = "METHOD_STREAMING_CHANGED"
+)
+
+// Severity summarizes how a Change affects clients.
+type Severity int
+
+const (
+	// SeverityInfo changes don't affect existing clients.
+	SeverityInfo Severity = iota
+	// SeverityWarning changes only require changes to code using the
+	// generated code.
+	SeverityWarning
+	// SeverityError changes break existing clients in the binary or JSON
+	// encodings.
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return "info"
+}
+
+// Severity returns the severity of changes that break b.
+func (b Breakage) Severity() Severity {
+	switch {
+	case b&(BreaksWire|BreaksJSON) != 0:
+		return SeverityError
+	case b&BreaksSource != 0:
+		return SeverityWarning
+	}
+	return SeverityInfo
+}
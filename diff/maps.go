@@ -0,0 +1,79 @@
+package diff
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// Map fields are repeated fields of a nested entry message that protoc
+// synthesizes, with a key field numbered 1 and a value field numbered 2.
+// They're compared as maps rather than as fields of the entry message.
+const (
+	mapKeyNumber   = 1
+	mapValueNumber = 2
+)
+
+// mapEntry returns the entry message of field if it's a map field, or nil.
+func mapEntry(set *fileSet, field *descriptor.FieldDescriptorProto) *descriptor.DescriptorProto {
+	if !repeated(field) || field.GetType() != descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+		return nil
+	}
+	entry := set.messages[field.GetTypeName()]
+	if !entry.GetOptions().GetMapEntry() {
+		return nil
+	}
+	return entry
+}
+
+// mapFieldType formats the type of field, e.g. "map<string, int32>" or
+// "repeated .helloworld.Foo".
+func mapFieldType(set *fileSet, field *descriptor.FieldDescriptorProto) string {
+	entry := mapEntry(set, field)
+	if entry == nil && repeated(field) {
+		return "repeated " + fieldTypeName(field)
+	}
+	if entry == nil {
+		return fieldTypeName(field)
+	}
+	var key, value string
+	for _, f := range entry.Field {
+		switch f.GetNumber() {
+		case mapKeyNumber:
+			key = fieldTypeName(f)
+		case mapValueNumber:
+			value = fieldTypeName(f)
+		}
+	}
+	return fmt.Sprintf("map<%s, %s>", key, value)
+}
+
+// diffMapField compares a field in previous with the field in current with
+// the same number, where either is a map field. Changes to the label are
+// reported as part of the type.
+func diffMapField(report *Report, prevMsg, currMsg, currField element, field, next *descriptor.FieldDescriptorProto) {
+	oldType, newType := mapFieldType(prevMsg.set, field), mapFieldType(currMsg.set, next)
+	if oldType == newType {
+		return
+	}
+	// Map entries are encoded as messages holding the key and value, so
+	// a map is wire compatible with a repeated message of the same shape.
+	var b Breakage
+	if !wireCompatible(field.GetType(), next.GetType()) || !repeated(field) || !repeated(next) {
+		b |= BreaksWire | BreaksJSON
+	} else if field.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE && next.GetType() == field.GetType() {
+		b |= compareTypes(prevMsg.set, currMsg.set, field.GetTypeName(), next.GetTypeName())
+	}
+	// JSON encodes maps as objects, and repeated fields as arrays.
+	if (mapEntry(prevMsg.set, field) == nil) != (mapEntry(currMsg.set, next) == nil) {
+		b |= BreaksJSON
+	}
+	report.Add(ProblemChangedMapField{
+		Position:     currField.pos(),
+		Message:      currMsg.name,
+		Field:        *field.Name,
+		OldType:      oldType,
+		NewType:      newType,
+		TypeBreakage: TypeBreakage{Breakage: b},
+	})
+}
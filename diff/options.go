@@ -0,0 +1,412 @@
+package diff
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// defaultOptionPolicy maps option names to how changing them breaks clients.
+// Custom options are named as in .proto files, e.g. "(auth.scope)" or
+// "(google.api.http).get"; fields of message valued options fall back to
+// the policy of the option.
+var defaultOptionPolicy = map[string]Breakage{
+	// File options only affect generated code.
+	"java_package":         BreaksSource,
+	"java_outer_classname": BreaksSource,
+	"java_multiple_files":  BreaksSource,
+	"go_package":           BreaksSource,
+	"csharp_namespace":     BreaksSource,
+	"objc_class_prefix":    BreaksSource,
+	"php_class_prefix":     BreaksSource,
+	"php_namespace":        BreaksSource,
+	"swift_prefix":         BreaksSource,
+	"optimize_for":         BreaksSource,
+
+	// Parsers older than protobuf 2.3 can't read repeated fields in the
+	// other encoding.
+	"packed":                  BreaksWire,
+	"message_set_wire_format": BreaksWire,
+	"ctype":                   BreaksSource,
+	"jstype":                  BreaksSource,
+
+	// The idempotency level doesn't change how calls are encoded, but
+	// gRPC stubs generated from it mark methods as safe or idempotent,
+	// which decides whether they are retried or sent as an HTTP GET.
+	"idempotency_level": BreaksSource,
+
+	"deprecated": 0,
+}
+
+// DefaultOptionPolicy returns the policy used when Options.OptionPolicy is
+// nil. Changes to options that aren't in it are still reported, but don't
+// break anything. The returned map is a copy, so callers can add or override
+// entries and pass it to Options.
+func DefaultOptionPolicy() map[string]Breakage {
+	policy := map[string]Breakage{}
+	for option, b := range defaultOptionPolicy {
+		policy[option] = b
+	}
+	return policy
+}
+
+// Options that are compared elsewhere.
+var skippedOptions = map[string]bool{
+	"map_entry":   true,
+	"allow_alias": true,
+}
+
+// diffOptions reports differences between two options messages of the same
+// type, e.g. two *descriptor.FieldOptions. Either may be nil. prevElem and
+// currElem are the elements declaring the options, and name identifies them in
+// changes. Custom options are decoded using the extensions in their sets.
+func diffOptions(report *Report, prevElem, currElem element, name string, previous, current proto.Message) {
+	prev, curr := reflect.ValueOf(previous), reflect.ValueOf(current)
+	t := prev.Type().Elem()
+	props := proto.GetProperties(t)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		prop := props.Prop[i]
+		if f.Tag.Get("protobuf") == "" || f.Type.Kind() != reflect.Ptr || skippedOptions[prop.OrigName] {
+			continue
+		}
+		oldValue, newValue := optionValue(prev, i, prop), optionValue(curr, i, prop)
+		if oldValue != newValue {
+			report.Add(ProblemChangedOption{
+				Position: currElem.pos(),
+				Element:  name,
+				Option:   prop.OrigName,
+				OldValue: oldValue,
+				NewValue: newValue,
+				Breakage: optionBreakage(report.policy, prop.OrigName),
+			})
+		}
+	}
+
+	prevCustom, currCustom := customOptions(prevElem.set, previous), customOptions(currElem.set, current)
+	options := []string{}
+	for option := range prevCustom {
+		options = append(options, option)
+	}
+	for option := range currCustom {
+		if _, ok := prevCustom[option]; !ok {
+			options = append(options, option)
+		}
+	}
+	sort.Strings(options)
+	for _, option := range options {
+		oldValue, ok := prevCustom[option]
+		if !ok {
+			oldValue = "unset"
+		}
+		newValue, ok := currCustom[option]
+		if !ok {
+			newValue = "unset"
+		}
+		if oldValue != newValue {
+			report.Add(ProblemChangedOption{
+				Position: currElem.pos(),
+				Element:  name,
+				Option:   option,
+				OldValue: oldValue,
+				NewValue: newValue,
+				Breakage: optionBreakage(report.policy, option),
+			})
+		}
+	}
+}
+
+// optionValue formats the value of the option in field i of msg, a pointer to
+// an options struct. Unset options with a default are formatted as their
+// default, other unset options as "unset".
+func optionValue(msg reflect.Value, i int, prop *proto.Properties) string {
+	var v reflect.Value
+	if !msg.IsNil() {
+		v = msg.Elem().Field(i)
+	}
+	if !v.IsValid() || v.IsNil() {
+		if !prop.HasDefault {
+			return "unset"
+		}
+		d := reflect.New(msg.Type().Elem().Field(i).Type.Elem()).Elem()
+		switch d.Kind() {
+		case reflect.Bool:
+			b, _ := strconv.ParseBool(prop.Default)
+			d.SetBool(b)
+		case reflect.Int32:
+			n, _ := strconv.ParseInt(prop.Default, 10, 32)
+			d.SetInt(n)
+		case reflect.String:
+			d.SetString(prop.Default)
+		}
+		return formatOption(d)
+	}
+	return formatOption(v.Elem())
+}
+
+func formatOption(v reflect.Value) string {
+	if v.Kind() == reflect.String {
+		return strconv.Quote(v.String())
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// fieldOptions returns the options of field with packed set to its effective
+// value, as repeated scalars are packed by default in proto3.
+func fieldOptions(file *descriptor.FileDescriptorProto, field *descriptor.FieldDescriptorProto) *descriptor.FieldOptions {
+	opts := &descriptor.FieldOptions{}
+	if field.Options != nil {
+		opts = proto.Clone(field.Options).(*descriptor.FieldOptions)
+	}
+	if opts.Packed == nil && packable(field) {
+		opts.Packed = proto.Bool(file.GetSyntax() == "proto3")
+	}
+	return opts
+}
+
+// packable reports whether field can use the packed encoding.
+func packable(field *descriptor.FieldDescriptorProto) bool {
+	if field.GetLabel() != descriptor.FieldDescriptorProto_LABEL_REPEATED {
+		return false
+	}
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_STRING,
+		descriptor.FieldDescriptorProto_TYPE_BYTES,
+		descriptor.FieldDescriptorProto_TYPE_MESSAGE,
+		descriptor.FieldDescriptorProto_TYPE_GROUP:
+		return false
+	}
+	return true
+}
+
+// extendable is implemented by options messages, which declare extension
+// ranges for custom options.
+type extendable interface {
+	ExtensionRangeArray() []proto.ExtensionRange
+}
+
+// customOptions decodes the custom options set on an options message, e.g.
+// (auth.scope) on a *descriptor.MethodOptions, using the extensions declared
+// in set. Fields of message valued options are flattened, e.g. (http).get.
+// Extensions that aren't declared in set are named by their number.
+func customOptions(set *fileSet, options proto.Message) map[string]string {
+	ext, ok := options.(extendable)
+	if !ok || reflect.ValueOf(options).IsNil() {
+		return nil
+	}
+	b, err := proto.Marshal(options)
+	if err != nil {
+		return nil
+	}
+	d := optionDecoder{set: set, values: map[string][]string{}, repeated: map[string]bool{}}
+	extensions := set.extensions["."+proto.MessageName(options)]
+	d.decode(b, func(number int32) (string, *descriptor.FieldDescriptorProto) {
+		for _, r := range ext.ExtensionRangeArray() {
+			if number < r.Start || number > r.End {
+				continue
+			}
+			if e, ok := extensions[number]; ok {
+				return "(" + e.name[1:] + ")", e.field
+			}
+			return fmt.Sprintf("(%d)", number), nil
+		}
+		return "", nil
+	})
+	return d.options()
+}
+
+// optionDecoder decodes option values from the wire format.
+type optionDecoder struct {
+	set *fileSet
+	// values holds the values of each option in the order they were
+	// decoded, and repeated the options declared as repeated fields.
+	values   map[string][]string
+	repeated map[string]bool
+}
+
+// decode decodes the fields in b. resolve returns the option name and
+// declaration for a field number; fields without a name are skipped, fields
+// without a declaration are formatted from their wire type.
+func (d *optionDecoder) decode(b []byte, resolve func(number int32) (string, *descriptor.FieldDescriptorProto)) {
+	for len(b) > 0 {
+		key, n := proto.DecodeVarint(b)
+		if n == 0 {
+			return
+		}
+		b = b[n:]
+		number, wireType := int32(key>>3), int(key&7)
+		var raw uint64
+		var bytes []byte
+		if wireType == proto.WireBytes {
+			var l int
+			raw, l = proto.DecodeVarint(b)
+			if l == 0 || uint64(len(b)-l) < raw {
+				return
+			}
+			bytes, n = b[l:l+int(raw)], l+int(raw)
+		} else {
+			raw, n = decodeScalar(wireType, b)
+		}
+		if n == 0 {
+			// Truncated, or a group, which isn't allowed in options.
+			return
+		}
+		b = b[n:]
+
+		name, field := resolve(number)
+		switch {
+		case name == "":
+		case field == nil:
+			d.add(name, formatWireValue(wireType, raw, bytes))
+		case field.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+			d.message(name, field.GetTypeName(), bytes)
+		case wireType == proto.WireBytes && packable(field):
+			d.packed(name, field, bytes)
+		default:
+			d.add(name, d.format(field, raw, bytes))
+		}
+		if field.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED {
+			d.repeated[name] = true
+		}
+	}
+}
+
+// message decodes the fields of a message valued option.
+func (d *optionDecoder) message(name, typeName string, b []byte) {
+	msg := d.set.messages[typeName]
+	d.decode(b, func(number int32) (string, *descriptor.FieldDescriptorProto) {
+		if msg != nil {
+			for _, field := range msg.Field {
+				if field.GetNumber() == number {
+					return name + "." + field.GetName(), field
+				}
+			}
+		}
+		return fmt.Sprintf("%s.%d", name, number), nil
+	})
+}
+
+// packed decodes a repeated scalar option in the packed encoding.
+func (d *optionDecoder) packed(name string, field *descriptor.FieldDescriptorProto, b []byte) {
+	wireType := proto.WireVarint
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_FIXED64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64,
+		descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		wireType = proto.WireFixed64
+	case descriptor.FieldDescriptorProto_TYPE_FIXED32,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED32,
+		descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		wireType = proto.WireFixed32
+	}
+	for len(b) > 0 {
+		raw, n := decodeScalar(wireType, b)
+		if n == 0 {
+			return
+		}
+		d.add(name, d.format(field, raw, nil))
+		b = b[n:]
+	}
+}
+
+// decodeScalar decodes a value of wireType from the start of b, and returns
+// it with the number of bytes read, or 0 if b doesn't hold a scalar.
+func decodeScalar(wireType int, b []byte) (uint64, int) {
+	n := 0
+	switch wireType {
+	case proto.WireVarint:
+		return proto.DecodeVarint(b)
+	case proto.WireFixed64:
+		n = 8
+	case proto.WireFixed32:
+		n = 4
+	}
+	if n == 0 || len(b) < n {
+		return 0, 0
+	}
+	var raw uint64
+	for i := n - 1; i >= 0; i-- {
+		raw = raw<<8 | uint64(b[i])
+	}
+	return raw, n
+}
+
+func (d *optionDecoder) add(name, value string) {
+	d.values[name] = append(d.values[name], value)
+}
+
+// format formats a scalar option value decoded as raw, or bytes for strings
+// and bytes.
+func (d *optionDecoder) format(field *descriptor.FieldDescriptorProto, raw uint64, bytes []byte) string {
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_STRING, descriptor.FieldDescriptorProto_TYPE_BYTES:
+		return strconv.Quote(string(bytes))
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return strconv.FormatBool(raw != 0)
+	case descriptor.FieldDescriptorProto_TYPE_INT32, descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		return strconv.FormatInt(int64(int32(raw)), 10)
+	case descriptor.FieldDescriptorProto_TYPE_INT64, descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return strconv.FormatInt(int64(raw), 10)
+	case descriptor.FieldDescriptorProto_TYPE_SINT32, descriptor.FieldDescriptorProto_TYPE_SINT64:
+		return strconv.FormatInt(int64(raw>>1)^-int64(raw&1), 10)
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		return strconv.FormatFloat(float64(math.Float32frombits(uint32(raw))), 'g', -1, 32)
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		return strconv.FormatFloat(math.Float64frombits(raw), 'g', -1, 64)
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		if enum, ok := d.set.enums[field.GetTypeName()]; ok {
+			for _, value := range enum.Value {
+				if value.GetNumber() == int32(raw) {
+					return value.GetName()
+				}
+			}
+		}
+		return strconv.FormatInt(int64(int32(raw)), 10)
+	}
+	return strconv.FormatUint(raw, 10)
+}
+
+// options returns the decoded options. Repeated options are formatted as a
+// list; for other options the last value wins, as when parsing.
+func (d *optionDecoder) options() map[string]string {
+	options := map[string]string{}
+	for name, values := range d.values {
+		if d.repeated[name] {
+			options[name] = "[" + strings.Join(values, ", ") + "]"
+		} else {
+			options[name] = values[len(values)-1]
+		}
+	}
+	return options
+}
+
+// formatWireValue formats the value of an option that isn't declared in the
+// descriptor set.
+func formatWireValue(wireType int, raw uint64, bytes []byte) string {
+	if wireType == proto.WireBytes {
+		return strconv.Quote(string(bytes))
+	}
+	return strconv.FormatUint(raw, 10)
+}
+
+// optionBreakage returns the policy for option. Fields of message valued
+// custom options without their own policy use the policy of the option, so
+// (http).get falls back to (http).
+func optionBreakage(policy map[string]Breakage, option string) Breakage {
+	for {
+		if b, ok := policy[option]; ok {
+			return b
+		}
+		i := strings.LastIndex(option, ".")
+		if i < 0 || i < strings.LastIndex(option, ")") {
+			return 0
+		}
+		option = option[:i]
+	}
+}
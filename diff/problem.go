@@ -2,11 +2,46 @@ package diff
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/golang/protobuf/protoc-gen-go/descriptor"
 )
 
+// Position is where the element a Change refers to is declared. Removed
+// elements are located in the previous file, everything else in the current
+// file. Line and Column are 1-based, and zero when the descriptor set was built
+// without --include_source_info.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) Pos() Position {
+	return p
+}
+
+func (p Position) String() string {
+	if p.Line == 0 {
+		return p.File
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// TypeBreakage is embedded in changes that replace the type of an element.
+// Breakage is how the encodings of the old and new types differ. The
+// generated code always changes, so Breaks adds BreaksSource.
+type TypeBreakage struct {
+	Breakage Breakage
+}
+
+func (t TypeBreakage) Breaks() Breakage {
+	return t.Breakage | BreaksSource
+}
+
 type ProblemChangedFieldType struct {
+	Position
 	Message string
 	Number  int32
 	Field   string
@@ -19,7 +54,122 @@ func (p ProblemChangedFieldType) String() string {
 		p.Field, p.Message, p.OldType, p.NewType)
 }
 
+// Some types share an encoding, so not every type change breaks clients. The
+// generated code always changes.
+func (p ProblemChangedFieldType) Breaks() Breakage {
+	b := BreaksSource
+	if !wireCompatible(*p.OldType, *p.NewType) {
+		b |= BreaksWire
+	}
+	if !jsonCompatible(*p.OldType, *p.NewType) {
+		b |= BreaksJSON
+	}
+	return b
+}
+
+func (p ProblemChangedFieldType) Kind() Kind {
+	return KindFieldTypeChanged
+}
+
+func (p ProblemChangedFieldType) Path() string {
+	return p.Message + "." + p.Field
+}
+
+func (p ProblemChangedFieldType) Values() (string, string) {
+	return p.OldType.String(), p.NewType.String()
+}
+
+type ProblemChangedFieldTypeName struct {
+	Position
+	Message     string
+	Field       string
+	OldTypeName string
+	NewTypeName string
+	TypeBreakage
+}
+
+func (p ProblemChangedFieldTypeName) String() string {
+	return fmt.Sprintf("changed message or enum type for field '%s' on message '%s': %s -> %s",
+		p.Field, p.Message, p.OldTypeName, p.NewTypeName)
+}
+
+func (p ProblemChangedFieldTypeName) Kind() Kind {
+	return KindFieldTypeNameChanged
+}
+
+func (p ProblemChangedFieldTypeName) Path() string {
+	return p.Message + "." + p.Field
+}
+
+func (p ProblemChangedFieldTypeName) Values() (string, string) {
+	return p.OldTypeName, p.NewTypeName
+}
+
+// ProblemChangedMapField is a map field whose key or value type changed, or
+// a field converted between a map and a repeated field.
+type ProblemChangedMapField struct {
+	Position
+	Message string
+	Field   string
+	// OldType and NewType are formatted as in .proto files, e.g.
+	// "map<string, int32>" or "repeated .helloworld.Entry".
+	OldType string
+	NewType string
+	TypeBreakage
+}
+
+func (p ProblemChangedMapField) String() string {
+	return fmt.Sprintf("changed map field '%s' on message '%s': %s -> %s",
+		p.Field, p.Message, p.OldType, p.NewType)
+}
+
+func (p ProblemChangedMapField) Kind() Kind {
+	return KindMapFieldChanged
+}
+
+func (p ProblemChangedMapField) Path() string {
+	return p.Message + "." + p.Field
+}
+
+func (p ProblemChangedMapField) Values() (string, string) {
+	return p.OldType, p.NewType
+}
+
+type ProblemReusedFieldNumber struct {
+	Position
+	Message  string
+	Number   int32
+	OldField string
+	NewField string
+	OldType  string
+	NewType  string
+}
+
+func (p ProblemReusedFieldNumber) String() string {
+	return fmt.Sprintf("reused field #%d on message '%s' for a different field: %s %s -> %s %s",
+		p.Number, p.Message, p.OldType, p.OldField, p.NewType, p.NewField)
+}
+
+// Old data is read as the new field, which is the worst kind of breakage: it
+// is silent.
+func (p ProblemReusedFieldNumber) Breaks() Breakage {
+	return BreaksAll
+}
+
+func (p ProblemReusedFieldNumber) Kind() Kind {
+	return KindFieldNumberReused
+}
+
+func (p ProblemReusedFieldNumber) Path() string {
+	return p.Message + "." + p.NewField
+}
+
+func (p ProblemReusedFieldNumber) Values() (string, string) {
+	return p.OldType + " " + p.OldField, p.NewType + " " + p.NewField
+}
+
 type ProblemChangedFieldName struct {
+	Position
 	Message string
 	Number  int32
 	OldName *string
@@ -31,7 +181,58 @@ func (p ProblemChangedFieldName) String() string {
 		p.Number, p.Message, *p.OldName, *p.NewName)
 }
 
+// The text format names fields by their name, so renaming a field breaks it
+// even when the JSON name is kept.
+func (p ProblemChangedFieldName) Breaks() Breakage {
+	return BreaksJSON | BreaksSource
+}
+
+func (p ProblemChangedFieldName) Kind() Kind {
+	return KindFieldNameChanged
+}
+
+func (p ProblemChangedFieldName) Path() string {
+	return p.Message + "." + *p.NewName
+}
+
+func (p ProblemChangedFieldName) Values() (string, string) {
+	return *p.OldName, *p.NewName
+}
+
+// ProblemChangedFieldJSONName is a field whose json_name option changed the
+// name of the field in the JSON encoding. Renamed fields are reported as
+// ProblemChangedFieldName instead. The binary encoding is unaffected.
+type ProblemChangedFieldJSONName struct {
+	Position
+	Message     string
+	Field       string
+	OldJSONName string
+	NewJSONName string
+}
+
+func (p ProblemChangedFieldJSONName) String() string {
+	return fmt.Sprintf("changed JSON name for field '%s' on message '%s': %s -> %s",
+		p.Field, p.Message, p.OldJSONName, p.NewJSONName)
+}
+
+func (p ProblemChangedFieldJSONName) Breaks() Breakage {
+	return BreaksJSON
+}
+
+func (p ProblemChangedFieldJSONName) Kind() Kind {
+	return KindFieldJSONNameChanged
+}
+
+func (p ProblemChangedFieldJSONName) Path() string {
+	return p.Message + "." + p.Field
+}
+
+func (p ProblemChangedFieldJSONName) Values() (string, string) {
+	return p.OldJSONName, p.NewJSONName
+}
+
 type ProblemChangedFieldLabel struct {
+	Position
 	Message  string
 	Field    string
 	OldLabel *descriptor.FieldDescriptorProto_Label
@@ -43,16 +244,232 @@ func (p ProblemChangedFieldLabel) String() string {
 		p.Field, p.Message, p.OldLabel, p.NewLabel)
 }
 
+func (p ProblemChangedFieldLabel) Breaks() Breakage {
+	return BreaksAll
+}
+
+func (p ProblemChangedFieldLabel) Kind() Kind {
+	return KindFieldLabelChanged
+}
+
+func (p ProblemChangedFieldLabel) Path() string {
+	return p.Message + "." + p.Field
+}
+
+func (p ProblemChangedFieldLabel) Values() (string, string) {
+	return p.OldLabel.String(), p.NewLabel.String()
+}
+
+// ProblemChangedFieldRequired is a proto2 field that became required or
+// optional. Parsers reject messages missing a required field, so either
+// old writers or new writers may produce messages the other side rejects.
+type ProblemChangedFieldRequired struct {
+	Position
+	Message  string
+	Field    string
+	Required bool
+}
+
+func (p ProblemChangedFieldRequired) String() string {
+	label := "optional"
+	if p.Required {
+		label = "required"
+	}
+	return fmt.Sprintf("made field '%s' on message '%s' %s", p.Field, p.Message, label)
+}
+
+func (p ProblemChangedFieldRequired) Breaks() Breakage {
+	return BreaksWire | BreaksJSON
+}
+
+func (p ProblemChangedFieldRequired) Kind() Kind {
+	return KindFieldRequiredChanged
+}
+
+func (p ProblemChangedFieldRequired) Path() string {
+	return p.Message + "." + p.Field
+}
+
+func (p ProblemChangedFieldRequired) Values() (string, string) {
+	if p.Required {
+		return "optional", "required"
+	}
+	return "required", "optional"
+}
+
+// ProblemChangedFieldDefault is a proto2 field whose default value changed,
+// so readers see a different value when the field isn't set.
+type ProblemChangedFieldDefault struct {
+	Position
+	Message    string
+	Field      string
+	OldDefault string
+	NewDefault string
+}
+
+func (p ProblemChangedFieldDefault) String() string {
+	return fmt.Sprintf("changed default for field '%s' on message '%s': %s -> %s",
+		p.Field, p.Message, p.OldDefault, p.NewDefault)
+}
+
+func (p ProblemChangedFieldDefault) Breaks() Breakage {
+	return BreaksWire | BreaksJSON
+}
+
+func (p ProblemChangedFieldDefault) Kind() Kind {
+	return KindFieldDefaultChanged
+}
+
+func (p ProblemChangedFieldDefault) Path() string {
+	return p.Message + "." + p.Field
+}
+
+func (p ProblemChangedFieldDefault) Values() (string, string) {
+	return p.OldDefault, p.NewDefault
+}
+
+// ProblemAddedRequiredField is a required field added to an existing
+// message. Messages written by old clients don't set it and fail to parse.
+type ProblemAddedRequiredField struct {
+	Position
+	Message string
+	Field   string
+}
+
+func (p ProblemAddedRequiredField) String() string {
+	return fmt.Sprintf("added required field '%s' to message '%s'", p.Field, p.Message)
+}
+
+func (p ProblemAddedRequiredField) Breaks() Breakage {
+	return BreaksWire | BreaksJSON
+}
+
+func (p ProblemAddedRequiredField) Kind() Kind {
+	return KindRequiredFieldAdded
+}
+
+func (p ProblemAddedRequiredField) Path() string {
+	return p.Message + "." + p.Field
+}
+
+type ProblemChangedFieldOneof struct {
+	Position
+	Message  string
+	Field    string
+	OldOneof string
+	NewOneof string
+}
+
+func (p ProblemChangedFieldOneof) String() string {
+	switch {
+	case p.OldOneof == "":
+		return fmt.Sprintf("moved field '%s' on message '%s' into oneof '%s'", p.Field, p.Message, p.NewOneof)
+	case p.NewOneof == "":
+		return fmt.Sprintf("moved field '%s' on message '%s' out of oneof '%s'", p.Field, p.Message, p.OldOneof)
+	default:
+		return fmt.Sprintf("moved field '%s' on message '%s' from oneof '%s' to oneof '%s'",
+			p.Field, p.Message, p.OldOneof, p.NewOneof)
+	}
+}
+
+// Setting one field of a oneof clears the others, so data that used to be
+// valid may now be silently dropped when parsed.
+func (p ProblemChangedFieldOneof) Breaks() Breakage {
+	return BreaksWire | BreaksSource
+}
+
+func (p ProblemChangedFieldOneof) Kind() Kind {
+	return KindFieldOneofChanged
+}
+
+func (p ProblemChangedFieldOneof) Path() string {
+	return p.Message + "." + p.Field
+}
+
+func (p ProblemChangedFieldOneof) Values() (string, string) {
+	return p.OldOneof, p.NewOneof
+}
+
+type ProblemRemovedOneof struct {
+	Position
+	Message string
+	Oneof   string
+}
+
+func (p ProblemRemovedOneof) String() string {
+	return fmt.Sprintf("removed oneof '%s' from message '%s'", p.Oneof, p.Message)
+}
+
+// Any fields that were in the oneof are reported as moved.
+func (p ProblemRemovedOneof) Breaks() Breakage {
+	return BreaksSource
+}
+
+func (p ProblemRemovedOneof) Kind() Kind {
+	return KindOneofRemoved
+}
+
+func (p ProblemRemovedOneof) Path() string {
+	return p.Message + "." + p.Oneof
+}
+
+type ProblemRenamedOneof struct {
+	Position
+	Message string
+	OldName string
+	NewName string
+}
+
+func (p ProblemRenamedOneof) String() string {
+	return fmt.Sprintf("renamed oneof on message '%s': %s -> %s", p.Message, p.OldName, p.NewName)
+}
+
+// Oneof names only appear in generated code.
+func (p ProblemRenamedOneof) Breaks() Breakage {
+	return BreaksSource
+}
+
+func (p ProblemRenamedOneof) Kind() Kind {
+	return KindOneofRenamed
+}
+
+func (p ProblemRenamedOneof) Path() string {
+	return p.Message + "." + p.NewName
+}
+
+func (p ProblemRenamedOneof) Values() (string, string) {
+	return p.OldName, p.NewName
+}
+
 type ProblemRemovedField struct {
+	Position
 	Message string
 	Field   string
+	// NumberReserved and NameReserved are set if the current version
+	// reserves the removed number or name.
+	NumberReserved bool
+	NameReserved   bool
 }
 
 func (p ProblemRemovedField) String() string {
-	return fmt.Sprintf("removed field '%s' from message '%s'", p.Field, p.Message)
+	return fmt.Sprintf("removed field '%s' from message '%s'", p.Field, p.Message) +
+		reservedSuffix(p.NumberReserved, p.NameReserved)
+}
+
+func (p ProblemRemovedField) Breaks() Breakage {
+	return removalBreakage(p.NumberReserved, p.NameReserved)
+}
+
+func (p ProblemRemovedField) Kind() Kind {
+	return KindFieldRemoved
+}
+
+func (p ProblemRemovedField) Path() string {
+	return p.Message + "." + p.Field
 }
 
 type ProblemRemovedServiceMethod struct {
+	Position
 	Service string
 	Name    string
 }
@@ -61,12 +478,29 @@ func (p ProblemRemovedServiceMethod) String() string {
 	return fmt.Sprintf("removed method '%s' from service '%s'", p.Name, p.Service)
 }
 
+func (p ProblemRemovedServiceMethod) Breaks() Breakage {
+	return BreaksAll
+}
+
+func (p ProblemRemovedServiceMethod) Kind() Kind {
+	return KindMethodRemoved
+}
+
+func (p ProblemRemovedServiceMethod) Path() string {
+	return p.Service + "." + p.Name
+}
+
+// ProblemChangedService is a method whose input or output type changed.
+// Message names aren't part of a gRPC call, so only the structure of the old
+// and new types matters to clients.
 type ProblemChangedService struct {
+	Position
 	Service string
 	Name    string
 	Side    string
 	OldType string
 	NewType string
+	TypeBreakage
 }
 
 func (p ProblemChangedService) String() string {
@@ -74,16 +508,47 @@ func (p ProblemChangedService) String() string {
 		p.Side, p.Name, p.Service, p.OldType, p.NewType)
 }
 
+func (p ProblemChangedService) Kind() Kind {
+	return KindMethodTypeChanged
+}
+
+func (p ProblemChangedService) Path() string {
+	return p.Service + "." + p.Name
+}
+
+func (p ProblemChangedService) Values() (string, string) {
+	return p.OldType, p.NewType
+}
+
 type ProblemRemovedEnumValue struct {
+	Position
 	Enum string
 	Name string
+	// NumberReserved and NameReserved are set if the current version
+	// reserves the removed number or name.
+	NumberReserved bool
+	NameReserved   bool
 }
 
 func (p ProblemRemovedEnumValue) String() string {
-	return fmt.Sprintf("removed value '%s' from enum '%s'", p.Name, p.Enum)
+	return fmt.Sprintf("removed value '%s' from enum '%s'", p.Name, p.Enum) +
+		reservedSuffix(p.NumberReserved, p.NameReserved)
+}
+
+func (p ProblemRemovedEnumValue) Breaks() Breakage {
+	return removalBreakage(p.NumberReserved, p.NameReserved)
+}
+
+func (p ProblemRemovedEnumValue) Kind() Kind {
+	return KindEnumValueRemoved
+}
+
+func (p ProblemRemovedEnumValue) Path() string {
+	return p.Enum + "." + p.Name
 }
 
 type ProblemChangeEnumValue struct {
+	Position
 	Enum     string
 	Name     string
 	OldValue int32
@@ -94,7 +559,124 @@ func (p ProblemChangeEnumValue) String() string {
 	return fmt.Sprintf("changed value '%s' on enum '%s': %d -> %d", p.Name, p.Enum, p.OldValue, p.NewValue)
 }
 
+// The name is unchanged, so only the binary encoding is affected.
+func (p ProblemChangeEnumValue) Breaks() Breakage {
+	return BreaksWire
+}
+
+func (p ProblemChangeEnumValue) Kind() Kind {
+	return KindEnumValueChanged
+}
+
+func (p ProblemChangeEnumValue) Path() string {
+	return p.Enum + "." + p.Name
+}
+
+func (p ProblemChangeEnumValue) Values() (string, string) {
+	return strconv.Itoa(int(p.OldValue)), strconv.Itoa(int(p.NewValue))
+}
+
+// ProblemRenamedEnumValue is an enum value whose number is unchanged but
+// whose name changed. The JSON and text formats encode enum values by name.
+type ProblemRenamedEnumValue struct {
+	Position
+	Enum    string
+	Number  int32
+	OldName string
+	NewName string
+}
+
+func (p ProblemRenamedEnumValue) String() string {
+	return fmt.Sprintf("renamed value %d on enum '%s': %s -> %s", p.Number, p.Enum, p.OldName, p.NewName)
+}
+
+func (p ProblemRenamedEnumValue) Breaks() Breakage {
+	return BreaksJSON | BreaksSource
+}
+
+func (p ProblemRenamedEnumValue) Kind() Kind {
+	return KindEnumValueRenamed
+}
+
+func (p ProblemRenamedEnumValue) Path() string {
+	return p.Enum + "." + p.NewName
+}
+
+func (p ProblemRenamedEnumValue) Values() (string, string) {
+	return p.OldName, p.NewName
+}
+
+// ProblemChangedEnumDefault is an enum whose first value changed. Readers
+// see the first value when a field of the enum isn't set; in proto3 it's
+// always the zero value, but a new alias can take its place.
+type ProblemChangedEnumDefault struct {
+	Position
+	Enum       string
+	OldDefault string
+	NewDefault string
+	OldNumber  int32
+	NewNumber  int32
+}
+
+func (p ProblemChangedEnumDefault) String() string {
+	return fmt.Sprintf("changed default value of enum '%s': %s -> %s", p.Enum, p.OldDefault, p.NewDefault)
+}
+
+// Readers only see a different value if the number changed, e.g. when
+// the values of a proto2 enum are reordered.
+func (p ProblemChangedEnumDefault) Breaks() Breakage {
+	if p.OldNumber != p.NewNumber {
+		return BreaksAll
+	}
+	return BreaksJSON | BreaksSource
+}
+
+func (p ProblemChangedEnumDefault) Kind() Kind {
+	return KindEnumDefaultChanged
+}
+
+func (p ProblemChangedEnumDefault) Path() string {
+	return p.Enum
+}
+
+func (p ProblemChangedEnumDefault) Values() (string, string) {
+	return p.OldDefault, p.NewDefault
+}
+
+// ProblemChangedEnumAlias is an enum whose allow_alias option was toggled.
+// Aliased values generate duplicate constants, which some languages reject
+// in switch statements.
+type ProblemChangedEnumAlias struct {
+	Position
+	Enum       string
+	AllowAlias bool
+}
+
+func (p ProblemChangedEnumAlias) String() string {
+	if p.AllowAlias {
+		return fmt.Sprintf("allowed aliases on enum '%s'", p.Enum)
+	}
+	return fmt.Sprintf("disallowed aliases on enum '%s'", p.Enum)
+}
+
+func (p ProblemChangedEnumAlias) Breaks() Breakage {
+	return BreaksSource
+}
+
+func (p ProblemChangedEnumAlias) Kind() Kind {
+	return KindEnumAliasChanged
+}
+
+func (p ProblemChangedEnumAlias) Path() string {
+	return p.Enum
+}
+
+func (p ProblemChangedEnumAlias) Values() (string, string) {
+	return strconv.FormatBool(!p.AllowAlias), strconv.FormatBool(p.AllowAlias)
+}
+
 type ProblemRemovedEnum struct {
+	Position
 	Enum string
 }
 
@@ -102,7 +684,59 @@ func (p ProblemRemovedEnum) String() string {
 	return fmt.Sprintf("removed enum '%s'", p.Enum)
 }
 
+func (p ProblemRemovedEnum) Breaks() Breakage {
+	return BreaksSource
+}
+
+func (p ProblemRemovedEnum) Kind() Kind {
+	return KindEnumRemoved
+}
+
+func (p ProblemRemovedEnum) Path() string {
+	return p.Enum
+}
+
+// ProblemMovedType is a message or enum that moved to another scope or
+// package without changing its structure, e.g. by nesting or unnesting it.
+// The binary encoding of the type is unchanged, but its fully qualified name
+// isn't: messages packed in an Any carry the name in their type URL, in
+// either encoding, so readers can no longer unpack them, and generated code
+// refers to the type by its new name.
+type ProblemMovedType struct {
+	Position
+	// Type is "message" or "enum".
+	Type    string
+	OldName string
+	NewName string
+}
+
+func (p ProblemMovedType) String() string {
+	return fmt.Sprintf("moved %s '%s' to '%s'", p.Type, p.OldName, p.NewName)
+}
+
+// Enums can't be packed in an Any, and their values are encoded by number
+// or by their unqualified name.
+func (p ProblemMovedType) Breaks() Breakage {
+	if p.Type == "enum" {
+		return BreaksSource
+	}
+	return BreaksAll
+}
+
+func (p ProblemMovedType) Kind() Kind {
+	return KindTypeMoved
+}
+
+func (p ProblemMovedType) Path() string {
+	return p.NewName
+}
+
+func (p ProblemMovedType) Values() (string, string) {
+	return p.OldName, p.NewName
+}
+
 type ProblemRemovedMessage struct {
+	Position
 	Message string
 }
 
@@ -110,15 +744,87 @@ func (p ProblemRemovedMessage) String() string {
 	return fmt.Sprintf("removed message '%s'", p.Message)
 }
 
+func (p ProblemRemovedMessage) Breaks() Breakage {
+	return BreaksSource
+}
+
+func (p ProblemRemovedMessage) Kind() Kind {
+	return KindMessageRemoved
+}
+
+func (p ProblemRemovedMessage) Path() string {
+	return p.Message
+}
+
 type ProblemRemovedFile struct {
-	File string
+	Position
 }
 
 func (p ProblemRemovedFile) String() string {
 	return fmt.Sprintf("removed file '%s'", p.File)
 }
 
+func (p ProblemRemovedFile) Breaks() Breakage {
+	return BreaksSource
+}
+
+func (p ProblemRemovedFile) Kind() Kind {
+	return KindFileRemoved
+}
+
+func (p ProblemRemovedFile) Path() string {
+	return p.File
+}
+
+type ProblemRenamedFile struct {
+	Position
+	OldName string
+	NewName string
+}
+
+func (p ProblemRenamedFile) String() string {
+	return fmt.Sprintf("renamed file '%s' -> '%s'", p.OldName, p.NewName)
+}
+
+// Files importing the old name no longer compile.
+func (p ProblemRenamedFile) Breaks() Breakage {
+	return BreaksSource
+}
+
+func (p ProblemRenamedFile) Kind() Kind {
+	return KindFileRenamed
+}
+
+func (p ProblemRenamedFile) Path() string {
+	return p.NewName
+}
+
+func (p ProblemRenamedFile) Values() (string, string) {
+	return p.OldName, p.NewName
+}
+
+type AddedFile struct {
+	Position
+}
+
+func (p AddedFile) String() string {
+	return fmt.Sprintf("added file '%s'", p.File)
+}
+
+func (p AddedFile) Breaks() Breakage {
+	return 0
+}
+
+func (p AddedFile) Kind() Kind {
+	return KindFileAdded
+}
+
+func (p AddedFile) Path() string {
+	return p.File
+}
+
 type ProblemRemovedService struct {
+	Position
 	Name string
 }
 
@@ -126,7 +832,54 @@ func (p ProblemRemovedService) String() string {
 	return fmt.Sprintf("removed service '%s'", p.Name)
 }
 
+func (p ProblemRemovedService) Breaks() Breakage {
+	return BreaksAll
+}
+
+func (p ProblemRemovedService) Kind() Kind {
+	return KindServiceRemoved
+}
+
+func (p ProblemRemovedService) Path() string {
+	return p.Name
+}
+
+// ProblemRenamedService is a service whose name changed while keeping most
+// of its methods. gRPC calls a method by a path holding the fully qualified
+// service name, so clients calling the old paths fail.
+type ProblemRenamedService struct {
+	Position
+	OldName string
+	NewName string
+	// OldMethods and NewMethods are the full gRPC method names, e.g.
+	// "/helloworld.Greeter/SayHello", of the methods in both services.
+	OldMethods []string
+	NewMethods []string
+}
+
+func (p ProblemRenamedService) String() string {
+	return fmt.Sprintf("renamed service '%s' to '%s': gRPC paths change from %s to %s",
+		p.OldName, p.NewName, strings.Join(p.OldMethods, ", "), strings.Join(p.NewMethods, ", "))
+}
+
+func (p ProblemRenamedService) Breaks() Breakage {
+	return BreaksAll
+}
+
+func (p ProblemRenamedService) Kind() Kind {
+	return KindServiceRenamed
+}
+
+func (p ProblemRenamedService) Path() string {
+	return p.NewName
+}
+
+func (p ProblemRenamedService) Values() (string, string) {
+	return p.OldName, p.NewName
+}
+
 type ProblemChangedServiceStreaming struct {
+	Position
 	Service   string
 	Name      string
 	Side      string
@@ -139,8 +892,55 @@ func (p ProblemChangedServiceStreaming) String() string {
 		p.Side, p.Name, p.Service, p.OldStream != nil, p.NewStream != nil)
 }
 
+func (p ProblemChangedServiceStreaming) Breaks() Breakage {
+	return BreaksAll
+}
+
+func (p ProblemChangedServiceStreaming) Kind() Kind {
+	return KindMethodStreamingChanged
+}
+
+func (p ProblemChangedServiceStreaming) Path() string {
+	return p.Service + "." + p.Name
+}
+
+func (p ProblemChangedServiceStreaming) Values() (string, string) {
+	return strconv.FormatBool(p.OldStream != nil), strconv.FormatBool(p.NewStream != nil)
+}
+
+type ProblemChangedOption struct {
+	Position
+	// Element is the file, or the fully qualified name of the element,
+	// declaring the option.
+	Element  string
+	Option   string
+	OldValue string
+	NewValue string
+	Breakage Breakage
+}
+
+func (p ProblemChangedOption) String() string {
+	return fmt.Sprintf("changed option '%s' on '%s': %s -> %s", p.Option, p.Element, p.OldValue, p.NewValue)
+}
+
+func (p ProblemChangedOption) Breaks() Breakage {
+	return p.Breakage
+}
+
+func (p ProblemChangedOption) Kind() Kind {
+	return KindOptionChanged
+}
+
+func (p ProblemChangedOption) Path() string {
+	return p.Element
+}
+
+func (p ProblemChangedOption) Values() (string, string) {
+	return p.OldValue, p.NewValue
+}
+
 type ProblemChangedPackage struct {
-	File   *descriptor.FileDescriptorProto
+	Position
 	OldPkg string
 	NewPkg string
 }
@@ -148,3 +948,405 @@ type ProblemChangedPackage struct {
 func (p ProblemChangedPackage) String() string {
 	return fmt.Sprintf("changed package name: %s -> %s", p.OldPkg, p.NewPkg)
 }
+
+func (p ProblemChangedPackage) Breaks() Breakage {
+	return BreaksAll
+}
+
+func (p ProblemChangedPackage) Kind() Kind {
+	return KindPackageChanged
+}
+
+func (p ProblemChangedPackage) Path() string {
+	return p.File
+}
+
+func (p ProblemChangedPackage) Values() (string, string) {
+	return p.OldPkg, p.NewPkg
+}
+
+// ProblemChangedSyntax is a file whose syntax changed between proto2 and
+// proto3, which changes field presence, default values and the handling of
+// unknown enum values, and with them the generated code.
+type ProblemChangedSyntax struct {
+	Position
+	OldSyntax string
+	NewSyntax string
+}
+
+func (p ProblemChangedSyntax) String() string {
+	return fmt.Sprintf("changed syntax: %s -> %s", p.OldSyntax, p.NewSyntax)
+}
+
+func (p ProblemChangedSyntax) Breaks() Breakage {
+	return BreaksSource
+}
+
+func (p ProblemChangedSyntax) Kind() Kind {
+	return KindSyntaxChanged
+}
+
+func (p ProblemChangedSyntax) Path() string {
+	return p.File
+}
+
+func (p ProblemChangedSyntax) Values() (string, string) {
+	return p.OldSyntax, p.NewSyntax
+}
+
+type AddedMessage struct {
+	Position
+	Message string
+}
+
+func (p AddedMessage) String() string {
+	return fmt.Sprintf("added message '%s'", p.Message)
+}
+
+func (p AddedMessage) Breaks() Breakage {
+	return 0
+}
+
+func (p AddedMessage) Kind() Kind {
+	return KindMessageAdded
+}
+
+func (p AddedMessage) Path() string {
+	return p.Message
+}
+
+type AddedField struct {
+	Position
+	Message string
+	Field   string
+}
+
+func (p AddedField) String() string {
+	return fmt.Sprintf("added field '%s' to message '%s'", p.Field, p.Message)
+}
+
+func (p AddedField) Breaks() Breakage {
+	return 0
+}
+
+func (p AddedField) Kind() Kind {
+	return KindFieldAdded
+}
+
+func (p AddedField) Path() string {
+	return p.Message + "." + p.Field
+}
+
+type AddedEnum struct {
+	Position
+	Enum string
+}
+
+func (p AddedEnum) String() string {
+	return fmt.Sprintf("added enum '%s'", p.Enum)
+}
+
+func (p AddedEnum) Breaks() Breakage {
+	return 0
+}
+
+func (p AddedEnum) Kind() Kind {
+	return KindEnumAdded
+}
+
+func (p AddedEnum) Path() string {
+	return p.Enum
+}
+
+type AddedEnumValue struct {
+	Position
+	Enum string
+	Name string
+}
+
+func (p AddedEnumValue) String() string {
+	return fmt.Sprintf("added value '%s' to enum '%s'", p.Name, p.Enum)
+}
+
+func (p AddedEnumValue) Breaks() Breakage {
+	return 0
+}
+
+func (p AddedEnumValue) Kind() Kind {
+	return KindEnumValueAdded
+}
+
+func (p AddedEnumValue) Path() string {
+	return p.Enum + "." + p.Name
+}
+
+type AddedService struct {
+	Position
+	Name string
+}
+
+func (p AddedService) String() string {
+	return fmt.Sprintf("added service '%s'", p.Name)
+}
+
+func (p AddedService) Breaks() Breakage {
+	return 0
+}
+
+func (p AddedService) Kind() Kind {
+	return KindServiceAdded
+}
+
+func (p AddedService) Path() string {
+	return p.Name
+}
+
+type AddedServiceMethod struct {
+	Position
+	Service string
+	Name    string
+}
+
+func (p AddedServiceMethod) String() string {
+	return fmt.Sprintf("added method '%s' to service '%s'", p.Name, p.Service)
+}
+
+func (p AddedServiceMethod) Breaks() Breakage {
+	return 0
+}
+
+func (p AddedServiceMethod) Kind() Kind {
+	return KindMethodAdded
+}
+
+func (p AddedServiceMethod) Path() string {
+	return p.Service + "." + p.Name
+}
+
+type AddedOneof struct {
+	Position
+	Message string
+	Oneof   string
+}
+
+func (p AddedOneof) String() string {
+	return fmt.Sprintf("added oneof '%s' to message '%s'", p.Oneof, p.Message)
+}
+
+func (p AddedOneof) Breaks() Breakage {
+	return 0
+}
+
+func (p AddedOneof) Kind() Kind {
+	return KindOneofAdded
+}
+
+func (p AddedOneof) Path() string {
+	return p.Message + "." + p.Oneof
+}
+
+type ProblemRemovedExtension struct {
+	Position
+	// Extendee is the message that was extended.
+	Extendee string
+	Number   int32
+	Name     string
+}
+
+func (p ProblemRemovedExtension) String() string {
+	return fmt.Sprintf("removed extension '%s' (#%d of '%s')", p.Name, p.Number, p.Extendee)
+}
+
+// Extension numbers can't be reserved, so the number may be reused.
+func (p ProblemRemovedExtension) Breaks() Breakage {
+	return BreaksAll
+}
+
+func (p ProblemRemovedExtension) Kind() Kind {
+	return KindExtensionRemoved
+}
+
+func (p ProblemRemovedExtension) Path() string {
+	return p.Name
+}
+
+// ProblemChangedExtension is an extension whose name, label or type changed.
+type ProblemChangedExtension struct {
+	Position
+	Extendee string
+	Number   int32
+	OldName  string
+	NewName  string
+	// OldType and NewType hold the label and type, e.g. "optional string".
+	OldType string
+	NewType string
+	TypeBreakage
+}
+
+func (p ProblemChangedExtension) String() string {
+	return fmt.Sprintf("changed extension #%d of '%s': %s %s -> %s %s",
+		p.Number, p.Extendee, p.OldType, p.OldName, p.NewType, p.NewName)
+}
+
+func (p ProblemChangedExtension) Kind() Kind {
+	return KindExtensionChanged
+}
+
+func (p ProblemChangedExtension) Path() string {
+	return p.NewName
+}
+
+func (p ProblemChangedExtension) Values() (string, string) {
+	return p.OldType + " " + p.OldName, p.NewType + " " + p.NewName
+}
+
+type ProblemRemovedExtensionRange struct {
+	Position
+	Message string
+	Start   int32
+	End     int32
+}
+
+func (p ProblemRemovedExtensionRange) String() string {
+	if p.Start == p.End {
+		return fmt.Sprintf("removed extension number %d from message '%s'", p.Start, p.Message)
+	}
+	return fmt.Sprintf("removed extension numbers %d to %d from message '%s'", p.Start, p.End, p.Message)
+}
+
+// Extensions using the numbers, which may be declared in files that aren't
+// part of the diff, no longer compile, and their values are no longer
+// parsed as extensions.
+func (p ProblemRemovedExtensionRange) Breaks() Breakage {
+	return BreaksAll
+}
+
+func (p ProblemRemovedExtensionRange) Kind() Kind {
+	return KindExtensionRangeRemoved
+}
+
+func (p ProblemRemovedExtensionRange) Path() string {
+	return p.Message
+}
+
+type AddedExtension struct {
+	Position
+	Extendee string
+	Number   int32
+	Name     string
+}
+
+func (p AddedExtension) String() string {
+	return fmt.Sprintf("added extension '%s' (#%d of '%s')", p.Name, p.Number, p.Extendee)
+}
+
+func (p AddedExtension) Breaks() Breakage {
+	return 0
+}
+
+func (p AddedExtension) Kind() Kind {
+	return KindExtensionAdded
+}
+
+func (p AddedExtension) Path() string {
+	return p.Name
+}
+
+type ProblemRemovedReservedRange struct {
+	Position
+	// Type is the message or enum that reserved the numbers.
+	Type  string
+	Start int32
+	End   int32
+}
+
+func (p ProblemRemovedReservedRange) String() string {
+	if p.Start == p.End {
+		return fmt.Sprintf("removed reservation of number %d from '%s'", p.Start, p.Type)
+	}
+	return fmt.Sprintf("removed reservation of numbers %d to %d from '%s'", p.Start, p.End, p.Type)
+}
+
+// The numbers may now be reused with a different meaning.
+func (p ProblemRemovedReservedRange) Breaks() Breakage {
+	return BreaksWire
+}
+
+func (p ProblemRemovedReservedRange) Kind() Kind {
+	return KindReservedRangeRemoved
+}
+
+func (p ProblemRemovedReservedRange) Path() string {
+	return p.Type
+}
+
+type ProblemRemovedReservedName struct {
+	Position
+	// Type is the message or enum that reserved the name.
+	Type string
+	Name string
+}
+
+func (p ProblemRemovedReservedName) String() string {
+	return fmt.Sprintf("removed reservation of name '%s' from '%s'", p.Name, p.Type)
+}
+
+// The name may now be reused with a different meaning.
+func (p ProblemRemovedReservedName) Breaks() Breakage {
+	return BreaksJSON
+}
+
+func (p ProblemRemovedReservedName) Kind() Kind {
+	return KindReservedNameRemoved
+}
+
+func (p ProblemRemovedReservedName) Path() string {
+	return p.Type
+}
+
+type ProblemReusedReservedNumber struct {
+	Position
+	// Type is the message or enum declaring the field or value.
+	Type   string
+	Name   string
+	Number int32
+}
+
+func (p ProblemReusedReservedNumber) String() string {
+	return fmt.Sprintf("reused reserved number %d for '%s' on '%s'", p.Number, p.Name, p.Type)
+}
+
+func (p ProblemReusedReservedNumber) Breaks() Breakage {
+	return BreaksWire
+}
+
+func (p ProblemReusedReservedNumber) Kind() Kind {
+	return KindReservedNumberReused
+}
+
+func (p ProblemReusedReservedNumber) Path() string {
+	return p.Type + "." + p.Name
+}
+
+type ProblemReusedReservedName struct {
+	Position
+	// Type is the message or enum declaring the field or value.
+	Type string
+	Name string
+}
+
+func (p ProblemReusedReservedName) String() string {
+	return fmt.Sprintf("reused reserved name '%s' on '%s'", p.Name, p.Type)
+}
+
+func (p ProblemReusedReservedName) Breaks() Breakage {
+	return BreaksJSON
+}
+
+func (p ProblemReusedReservedName) Kind() Kind {
+	return KindReservedNameReused
+}
+
+func (p ProblemReusedReservedName) Path() string {
+	return p.Type + "." + p.Name
+}
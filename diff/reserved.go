@@ -0,0 +1,183 @@
+package diff
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// reservations are the numbers and names reserved by a message or enum, along
+// with the SourceCodeInfo paths of their declarations.
+type reservations struct {
+	ranges    []numberRange
+	names     []string
+	rangePath int32
+	namePath  int32
+}
+
+// numberRange is an inclusive range of reserved numbers, declared at index.
+type numberRange struct {
+	start, end int32
+	index      int
+}
+
+// declared is a field or enum value number and name, declared at index.
+type declared struct {
+	number int32
+	name   string
+	index  int
+}
+
+func messageReservations(msg *descriptor.DescriptorProto) reservations {
+	r := reservations{
+		names:     msg.ReservedName,
+		rangePath: msgReservedRangePath,
+		namePath:  msgReservedNamePath,
+	}
+	for i, rr := range msg.ReservedRange {
+		// The end of a message's reserved range is exclusive.
+		r.ranges = append(r.ranges, numberRange{rr.GetStart(), rr.GetEnd() - 1, i})
+	}
+	return r
+}
+
+// enumDescriptorReservations holds the EnumDescriptorProto fields added in
+// protobuf 3.5. The vendored descriptor package predates them, so they end up
+// in XXX_unrecognized.
+type enumDescriptorReservations struct {
+	ReservedRange    []*descriptor.DescriptorProto_ReservedRange `protobuf:"bytes,4,rep,name=reserved_range,json=reservedRange"`
+	ReservedName     []string                                    `protobuf:"bytes,5,rep,name=reserved_name,json=reservedName"`
+	XXX_unrecognized []byte                                      `json:"-"`
+}
+
+func (m *enumDescriptorReservations) Reset()         { *m = enumDescriptorReservations{} }
+func (m *enumDescriptorReservations) String() string { return proto.CompactTextString(m) }
+func (*enumDescriptorReservations) ProtoMessage()    {}
+
+func enumReservations(enum *descriptor.EnumDescriptorProto) reservations {
+	r := reservations{
+		rangePath: enumReservedRangePath,
+		namePath:  enumReservedNamePath,
+	}
+	var decoded enumDescriptorReservations
+	if err := proto.Unmarshal(enum.XXX_unrecognized, &decoded); err != nil {
+		return r
+	}
+	r.names = decoded.ReservedName
+	for i, rr := range decoded.ReservedRange {
+		// Unlike messages, the end of an enum's reserved range is inclusive.
+		r.ranges = append(r.ranges, numberRange{rr.GetStart(), rr.GetEnd(), i})
+	}
+	return r
+}
+
+func (r reservations) hasNumber(number int32) bool {
+	for _, nr := range r.ranges {
+		if nr.start <= number && number <= nr.end {
+			return true
+		}
+	}
+	return false
+}
+
+func (r reservations) hasName(name string) bool {
+	for _, n := range r.names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// minus returns the parts of nr not covered by ranges.
+func (nr numberRange) minus(ranges []numberRange) []numberRange {
+	left := []numberRange{nr}
+	for _, c := range ranges {
+		next := []numberRange{}
+		for _, l := range left {
+			if c.end < l.start || c.start > l.end {
+				next = append(next, l)
+				continue
+			}
+			if l.start < c.start {
+				next = append(next, numberRange{l.start, c.start - 1, l.index})
+			}
+			if l.end > c.end {
+				next = append(next, numberRange{c.end + 1, l.end, l.index})
+			}
+		}
+		left = next
+	}
+	return left
+}
+
+// diffReservations reports numbers and names that were reserved in prev but
+// aren't reserved in curr, and fields or enum values in current that use
+// them. path is the SourceCodeInfo field number of the fields or values.
+func diffReservations(report *Report, prevElem, currElem element, prev, curr reservations, current []declared, path int32) {
+	for _, nr := range prev.ranges {
+		for _, dropped := range nr.minus(curr.ranges) {
+			report.Add(ProblemRemovedReservedRange{
+				Position: prevElem.pos(prev.rangePath, int32(dropped.index)),
+				Type:     prevElem.name,
+				Start:    dropped.start,
+				End:      dropped.end,
+			})
+		}
+	}
+	for i, name := range prev.names {
+		if !curr.hasName(name) {
+			report.Add(ProblemRemovedReservedName{
+				Position: prevElem.pos(prev.namePath, int32(i)),
+				Type:     prevElem.name,
+				Name:     name,
+			})
+		}
+	}
+	for _, decl := range current {
+		if prev.hasNumber(decl.number) {
+			report.Add(ProblemReusedReservedNumber{
+				Position: currElem.pos(path, int32(decl.index)),
+				Type:     currElem.name,
+				Name:     decl.name,
+				Number:   decl.number,
+			})
+		}
+		if prev.hasName(decl.name) {
+			report.Add(ProblemReusedReservedName{
+				Position: currElem.pos(path, int32(decl.index)),
+				Type:     currElem.name,
+				Name:     decl.name,
+			})
+		}
+	}
+}
+
+// removalBreakage returns how removing a field or enum value breaks clients.
+// Reserving the number keeps the binary encoding safe from reuse, reserving
+// the name does the same for JSON and the text format. Reserving both marks
+// the removal as deliberate, so the source change is accepted too.
+func removalBreakage(numberReserved, nameReserved bool) Breakage {
+	if numberReserved && nameReserved {
+		return 0
+	}
+	b := BreaksSource
+	if !numberReserved {
+		b |= BreaksWire
+	}
+	if !nameReserved {
+		b |= BreaksJSON
+	}
+	return b
+}
+
+func reservedSuffix(numberReserved, nameReserved bool) string {
+	switch {
+	case numberReserved && nameReserved:
+		return " (number and name reserved)"
+	case numberReserved:
+		return " (number reserved)"
+	case nameReserved:
+		return " (name reserved)"
+	}
+	return ""
+}
@@ -0,0 +1,339 @@
+package diff
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// fileSet indexes the messages and enums declared in one side of a diff by
+// their fully qualified names.
+type fileSet struct {
+	messages map[string]*descriptor.DescriptorProto
+	enums    map[string]*descriptor.EnumDescriptorProto
+	// decls holds where each message and enum is declared.
+	decls map[string]element
+	// extensions holds the extension fields declared in the set, by the
+	// fully qualified name of the extended message and the field number.
+	extensions map[string]map[int32]extension
+	// index holds the line and column of the declarations in each file by
+	// their SourceCodeInfo path. Files are indexed on first use.
+	index map[*descriptor.FileDescriptorProto]map[string]Position
+}
+
+// extension is an extension field and its fully qualified name.
+type extension struct {
+	name  string
+	field *descriptor.FieldDescriptorProto
+}
+
+func newFileSet(files []*descriptor.FileDescriptorProto) *fileSet {
+	set := &fileSet{
+		messages:   map[string]*descriptor.DescriptorProto{},
+		enums:      map[string]*descriptor.EnumDescriptorProto{},
+		decls:      map[string]element{},
+		extensions: map[string]map[int32]extension{},
+		index:      map[*descriptor.FileDescriptorProto]map[string]Position{},
+	}
+	for _, file := range files {
+		scope := fileScope(set, file)
+		for i, msg := range file.MessageType {
+			set.addMessage(scope.child(msg.GetName(), fileMessageTypePath, int32(i)), msg)
+		}
+		for i, enum := range file.EnumType {
+			set.addEnum(scope.child(enum.GetName(), fileEnumTypePath, int32(i)), enum)
+		}
+		set.addExtensions(scope.name, file.Extension)
+	}
+	return set
+}
+
+// positions returns the positions of the declarations in file, keyed by
+// pathKey. Only the line and column are set.
+func (s *fileSet) positions(file *descriptor.FileDescriptorProto) map[string]Position {
+	if positions, ok := s.index[file]; ok {
+		return positions
+	}
+	positions := map[string]Position{}
+	for _, loc := range file.GetSourceCodeInfo().GetLocation() {
+		key := pathKey(loc.Path)
+		if _, ok := positions[key]; !ok && len(loc.Span) >= 3 {
+			positions[key] = Position{Line: int(loc.Span[0]) + 1, Column: int(loc.Span[1]) + 1}
+		}
+	}
+	s.index[file] = positions
+	return positions
+}
+
+func (s *fileSet) addMessage(decl element, msg *descriptor.DescriptorProto) {
+	s.messages[decl.name] = msg
+	s.decls[decl.name] = decl
+	for i, nested := range msg.NestedType {
+		s.addMessage(decl.child(nested.GetName(), msgNestedTypePath, int32(i)), nested)
+	}
+	for i, enum := range msg.EnumType {
+		s.addEnum(decl.child(enum.GetName(), msgEnumTypePath, int32(i)), enum)
+	}
+	s.addExtensions(decl.name, msg.Extension)
+}
+
+func (s *fileSet) addEnum(decl element, enum *descriptor.EnumDescriptorProto) {
+	s.enums[decl.name] = enum
+	s.decls[decl.name] = decl
+}
+
+func (s *fileSet) addExtensions(scope string, fields []*descriptor.FieldDescriptorProto) {
+	for _, field := range fields {
+		extendee := field.GetExtendee()
+		if s.extensions[extendee] == nil {
+			s.extensions[extendee] = map[int32]extension{}
+		}
+		s.extensions[extendee][field.GetNumber()] = extension{
+			name:  scope + "." + field.GetName(),
+			field: field,
+		}
+	}
+}
+
+// compareTypes returns how clients break when a reference to the message or
+// enum named prevName in prev is changed to currName in curr. Types that
+// can't be resolved, e.g. because they were imported and the descriptor sets
+// were built without --include_imports, are assumed to break everything.
+func compareTypes(prev, curr *fileSet, prevName, currName string) Breakage {
+	c := &typeComparison{prev: prev, curr: curr, seen: map[[2]string]bool{}}
+	return c.types(prevName, currName)
+}
+
+// typeComparison structurally compares message graphs in two file sets.
+type typeComparison struct {
+	prev, curr *fileSet
+	// seen holds the pairs of types already being compared. They are
+	// assumed to match when reached again, which terminates recursive
+	// types.
+	seen map[[2]string]bool
+}
+
+func (c *typeComparison) types(prevName, currName string) Breakage {
+	pair := [2]string{prevName, currName}
+	if c.seen[pair] {
+		return 0
+	}
+	c.seen[pair] = true
+	if prevMsg, ok := c.prev.messages[prevName]; ok {
+		if currMsg, ok := c.curr.messages[currName]; ok {
+			return c.messages(prevMsg, currMsg)
+		}
+	}
+	if prevEnum, ok := c.prev.enums[prevName]; ok {
+		if currEnum, ok := c.curr.enums[currName]; ok {
+			return compareEnums(prevEnum, currEnum)
+		}
+	}
+	return BreaksAll
+}
+
+// messages returns how encoded prev messages break when read as curr
+// messages, and vice versa. Fields that only exist in curr don't break
+// either encoding. Fields referring to differently named types are compared
+// recursively; references to the same name are assumed to match, as changes
+// to that type are reported on their own.
+func (c *typeComparison) messages(prev, curr *descriptor.DescriptorProto) Breakage {
+	var b Breakage
+	fields := map[int32]*descriptor.FieldDescriptorProto{}
+	for _, field := range curr.Field {
+		fields[field.GetNumber()] = field
+	}
+	for _, field := range prev.Field {
+		next, exists := fields[field.GetNumber()]
+		if !exists {
+			b |= BreaksWire | BreaksJSON
+			continue
+		}
+		if field.GetLabel() != next.GetLabel() {
+			b |= BreaksWire | BreaksJSON
+		}
+		if !wireCompatible(field.GetType(), next.GetType()) {
+			b |= BreaksWire
+		}
+		if !jsonCompatible(field.GetType(), next.GetType()) {
+			b |= BreaksJSON
+		}
+		if field.GetName() != next.GetName() || jsonName(field) != jsonName(next) {
+			b |= BreaksJSON
+		}
+		if field.GetTypeName() != next.GetTypeName() {
+			b |= c.types(field.GetTypeName(), next.GetTypeName()) &^ BreaksSource
+		}
+	}
+	return b
+}
+
+// compareEnums returns how encoded prev values break when read as curr
+// values. The binary encoding only uses numbers, JSON and the text format
+// use names.
+func compareEnums(prev, curr *descriptor.EnumDescriptorProto) Breakage {
+	var b Breakage
+	names := map[int32]string{}
+	for _, value := range curr.Value {
+		names[value.GetNumber()] = value.GetName()
+	}
+	for _, value := range prev.Value {
+		name, exists := names[value.GetNumber()]
+		if !exists {
+			b |= BreaksWire | BreaksJSON
+			continue
+		}
+		if name != value.GetName() {
+			b |= BreaksJSON
+		}
+	}
+	return b
+}
+
+// findMovedTypes replaces messages and enums that were removed from one
+// scope and added to another with the same name and structure, e.g. when
+// unnesting a message or moving it to another package, with
+// ProblemMovedType. Types nested in a moved type move with it and aren't
+// reported on their own.
+func findMovedTypes(report *Report, prev, curr *fileSet) {
+	added := map[string][]string{}
+	for _, name := range sortedDecls(curr) {
+		if _, exists := prev.decls[name]; !exists {
+			short := name[strings.LastIndex(name, ".")+1:]
+			added[short] = append(added[short], name)
+		}
+	}
+
+	moved := map[string]string{}
+	reported := map[string]bool{}
+	taken := map[string]bool{}
+	for _, prevName := range sortedDecls(prev) {
+		if _, exists := curr.decls[prevName]; exists {
+			continue
+		}
+		i := strings.LastIndex(prevName, ".")
+		if parent, ok := moved[prevName[:i]]; ok {
+			if _, exists := curr.decls[parent+prevName[i:]]; exists {
+				moved[prevName] = parent + prevName[i:]
+				taken[parent+prevName[i:]] = true
+				continue
+			}
+		}
+		for _, currName := range added[prevName[i+1:]] {
+			if !taken[currName] && identicalTypes(prev, curr, prevName, currName) {
+				moved[prevName] = currName
+				reported[prevName] = true
+				taken[currName] = true
+				break
+			}
+		}
+	}
+	if len(reported) == 0 {
+		return
+	}
+
+	// Types nested in a removed type, or in a message of a removed file,
+	// aren't reported as removed, so they replace their addition instead.
+	removed := map[string]bool{}
+	for _, change := range report.Changes {
+		switch c := change.(type) {
+		case ProblemRemovedMessage:
+			removed[c.Message] = true
+		case ProblemRemovedEnum:
+			removed[c.Enum] = true
+		}
+	}
+	movedFrom := map[string]string{}
+	for prevName := range reported {
+		if !removed[prevName] {
+			movedFrom[moved[prevName]] = prevName
+		}
+	}
+
+	changes := []Change{}
+	for _, change := range report.Changes {
+		var name string
+		switch c := change.(type) {
+		case ProblemRemovedMessage:
+			name = c.Message
+		case ProblemRemovedEnum:
+			name = c.Enum
+		case AddedMessage:
+			name = movedFrom[c.Message]
+			if name == "" && taken[c.Message] {
+				continue
+			}
+		case AddedEnum:
+			name = movedFrom[c.Enum]
+			if name == "" && taken[c.Enum] {
+				continue
+			}
+		}
+		if reported[name] {
+			changes = append(changes, movedType(report, prev, curr, name, moved[name])...)
+			delete(reported, name)
+			continue
+		}
+		changes = append(changes, change)
+	}
+	// Types moved into an added type or file aren't reported as added
+	// either.
+	for _, prevName := range sortedDecls(prev) {
+		if reported[prevName] {
+			changes = append(changes, movedType(report, prev, curr, prevName, moved[prevName])...)
+		}
+	}
+	report.Changes = changes
+}
+
+// movedType returns a ProblemMovedType for the type moved from prevName to
+// currName, followed by the changes within the type, such as to its options
+// or to nested types that no field refers to. Option changes are judged by
+// the policy of report.
+func movedType(report *Report, prev, curr *fileSet, prevName, currName string) []Change {
+	p := ProblemMovedType{
+		Position: curr.decls[currName].pos(),
+		Type:     "message",
+		OldName:  prevName,
+		NewName:  currName,
+	}
+	moved := &Report{policy: report.policy}
+	if _, ok := prev.enums[prevName]; ok {
+		p.Type = "enum"
+		diffEnum(moved, prev.decls[prevName], curr.decls[currName], prev.enums[prevName], curr.enums[currName])
+	} else {
+		diffMsg(moved, prev.decls[prevName], curr.decls[currName], prev.messages[prevName], curr.messages[currName])
+	}
+	return append([]Change{p}, moved.Changes...)
+}
+
+// identicalTypes reports whether the message or enum prevName in prev has
+// the same fields or values as currName in curr.
+func identicalTypes(prev, curr *fileSet, prevName, currName string) bool {
+	if prevMsg, ok := prev.messages[prevName]; ok {
+		currMsg, ok := curr.messages[currName]
+		if !ok || len(prevMsg.Field) != len(currMsg.Field) {
+			return false
+		}
+	} else {
+		prevEnum, currEnum := prev.enums[prevName], curr.enums[currName]
+		if prevEnum == nil || currEnum == nil || len(prevEnum.Value) != len(currEnum.Value) {
+			return false
+		}
+	}
+	return compareTypes(prev, curr, prevName, currName) == 0
+}
+
+// sortedDecls returns the names of the messages and enums in set, except for
+// map entries.
+func sortedDecls(set *fileSet) []string {
+	names := []string{}
+	for name := range set.decls {
+		if !set.messages[name].GetOptions().GetMapEntry() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
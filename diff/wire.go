@@ -0,0 +1,91 @@
+package diff
+
+import (
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// Field types are wire compatible when they share an encoding. Values may
+// still be truncated or reinterpreted, e.g. a negative int32 read as a
+// uint32, in the same way as a C++ cast.
+// See https://developers.google.com/protocol-buffers/docs/proto3#updating
+var wireEncodings = map[descriptor.FieldDescriptorProto_Type]int{
+	descriptor.FieldDescriptorProto_TYPE_INT32:    1,
+	descriptor.FieldDescriptorProto_TYPE_INT64:    1,
+	descriptor.FieldDescriptorProto_TYPE_UINT32:   1,
+	descriptor.FieldDescriptorProto_TYPE_UINT64:   1,
+	descriptor.FieldDescriptorProto_TYPE_BOOL:     1,
+	descriptor.FieldDescriptorProto_TYPE_ENUM:     1,
+	descriptor.FieldDescriptorProto_TYPE_SINT32:   2,
+	descriptor.FieldDescriptorProto_TYPE_SINT64:   2,
+	descriptor.FieldDescriptorProto_TYPE_FIXED32:  3,
+	descriptor.FieldDescriptorProto_TYPE_SFIXED32: 3,
+	descriptor.FieldDescriptorProto_TYPE_FIXED64:  4,
+	descriptor.FieldDescriptorProto_TYPE_SFIXED64: 4,
+	// Strings must be valid UTF-8, so bytes are only compatible with
+	// strings if they happen to be.
+	descriptor.FieldDescriptorProto_TYPE_STRING: 5,
+	descriptor.FieldDescriptorProto_TYPE_BYTES:  5,
+}
+
+// wireCompatible reports whether a field of type a can be changed to type b
+// without breaking the binary encoding.
+func wireCompatible(a, b descriptor.FieldDescriptorProto_Type) bool {
+	if a == b {
+		return true
+	}
+	// Embedded messages are compatible with bytes holding an encoded
+	// message.
+	bytes, msg := descriptor.FieldDescriptorProto_TYPE_BYTES, descriptor.FieldDescriptorProto_TYPE_MESSAGE
+	if (a == bytes && b == msg) || (a == msg && b == bytes) {
+		return true
+	}
+	enc, ok := wireEncodings[a]
+	return ok && enc == wireEncodings[b]
+}
+
+// jsonNumbers are the field types encoded as JSON numbers, or strings holding
+// a number in the case of 64-bit types. Parsers accept either form for all of
+// them.
+var jsonNumbers = map[descriptor.FieldDescriptorProto_Type]bool{
+	descriptor.FieldDescriptorProto_TYPE_INT32:    true,
+	descriptor.FieldDescriptorProto_TYPE_INT64:    true,
+	descriptor.FieldDescriptorProto_TYPE_UINT32:   true,
+	descriptor.FieldDescriptorProto_TYPE_UINT64:   true,
+	descriptor.FieldDescriptorProto_TYPE_SINT32:   true,
+	descriptor.FieldDescriptorProto_TYPE_SINT64:   true,
+	descriptor.FieldDescriptorProto_TYPE_FIXED32:  true,
+	descriptor.FieldDescriptorProto_TYPE_FIXED64:  true,
+	descriptor.FieldDescriptorProto_TYPE_SFIXED32: true,
+	descriptor.FieldDescriptorProto_TYPE_SFIXED64: true,
+}
+
+// jsonCompatible reports whether a field of type a can be changed to type b
+// without breaking the JSON encoding.
+func jsonCompatible(a, b descriptor.FieldDescriptorProto_Type) bool {
+	return a == b || (jsonNumbers[a] && jsonNumbers[b])
+}
+
+// jsonName returns the name of field in the JSON encoding: its json_name
+// option, or else its name in lowerCamelCase, as protoc derives it.
+// Descriptors written by protoc always set json_name, but other tools may
+// not.
+func jsonName(field *descriptor.FieldDescriptorProto) string {
+	if field.JsonName != nil {
+		return field.GetJsonName()
+	}
+	name := []byte{}
+	upper := false
+	for _, c := range []byte(field.GetName()) {
+		switch {
+		case c == '_':
+			upper = true
+		case upper && 'a' <= c && c <= 'z':
+			name = append(name, c-'a'+'A')
+			upper = false
+		default:
+			name = append(name, c)
+			upper = false
+		}
+	}
+	return string(name)
+}